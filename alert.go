@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/uptrace/bun"
+)
+
+// alertLevel is a price the latest sample is watched against for crossing
+// it upward, or downward when up is false. above holds which side of it the
+// price was on at the last poll.
+type alertLevel struct {
+	price float64
+	up    bool
+	above bool
+	known bool
+}
+
+// crossed reports whether price has crossed the level in its direction
+// since the last recorded price.
+func (l *alertLevel) crossed(price float64) bool {
+	above := price > l.price
+	return l.known && above != l.above && above == l.up
+}
+
+// record saves which side of the level price is on.
+func (l *alertLevel) record(price float64) {
+	l.above, l.known = price > l.price, true
+}
+
+// watchAlerts polls the latest sample every interval and publishes a note
+// with the chart to cfg.relays whenever the price rises above above or
+// falls below below. A level of 0 is not watched.
+func watchAlerts(ctx context.Context, bundb *bun.DB, cfg *handlerConfig, above, below float64, interval time.Duration) {
+	var watched []*alertLevel
+	if above > 0 {
+		watched = append(watched, &alertLevel{price: above, up: true})
+	}
+	if below > 0 {
+		watched = append(watched, &alertLevel{price: below})
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		if err := checkAlerts(ctx, bundb, cfg, watched); err != nil {
+			slog.Error("failed to check alerts", "error", err)
+		}
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func checkAlerts(ctx context.Context, bundb *bun.DB, cfg *handlerConfig, levels []*alertLevel) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var latest BtcLog
+	err := selectLogs(bundb, cfg.defaults.asset()).Order("timestamp DESC").Limit(1).Scan(ctx, &latest)
+	if err != nil {
+		return err
+	}
+	price := latest.price(cfg.defaults.field())
+	cur := cfg.defaults.Currency
+	// the levels are in the chart currency, the samples in the base one
+	if base := cfg.defaults.Base; base.Code != "" && base != cur {
+		rate, err := fxRates.rate(ctx, base.Code, cur.Code)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s to %s: %w", base.Code, cur.Code, err)
+		}
+		price *= rate
+	}
+	for _, l := range levels {
+		if !l.crossed(price) {
+			l.record(price)
+			continue
+		}
+		// the crossing is recorded only once it is announced so that a
+		// failure is retried at the next poll
+		if err := announce(ctx, bundb, cfg, l, price); err != nil {
+			return err
+		}
+		l.record(price)
+	}
+	return nil
+}
+
+// announce publishes the note with the chart for price crossing l.
+func announce(ctx context.Context, bundb *bun.DB, cfg *handlerConfig, l *alertLevel, price float64) error {
+	cur := cfg.defaults.Currency
+	direction, verb := "below", "下抜け"
+	if l.up {
+		direction, verb = "above", "上抜け"
+	}
+	slog.Info("price crossed alert level", "level", l.price, "direction", direction, "price", price)
+
+	img, err := generate(ctx, bundb, 180, cfg.defaults, "", cfg.upload)
+	if err != nil {
+		return err
+	}
+	ev := nostr.Event{
+		PubKey:    cfg.pub,
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindTextNote,
+		Content: fmt.Sprintf("₿ %s%s を%sしました (%s%s)\n%s\n#ビットコインチャート",
+			cur.Symbol, cur.format(l.price), verb, cur.Symbol, cur.format(price), img.URL),
+		Tags: nostr.Tags{{"t", "ビットコインチャート"}, img.imeta()},
+	}
+	if err := ev.Sign(cfg.sk); err != nil {
+		return err
+	}
+	result := publish(ctx, cfg.relays, ev)
+	for _, status := range result {
+		if status == "ok" {
+			return nil
+		}
+	}
+	if len(result) > 0 {
+		return fmt.Errorf("failed to publish alert %s to any relay", ev.ID)
+	}
+	return nil
+}
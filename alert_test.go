@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckAlertsConverts(t *testing.T) {
+	bundb := newTestDB(t, 300)
+	if _, err := bundb.NewUpdate().Model((*BtcLog)(nil)).Set("last = last / 150").Set("bid = bid / 150").Set("ask = ask / 150").Where("1 = 1").Exec(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cfg := newTestConfig(t)
+	cfg.defaults.Base = currencies["USD"]
+
+	fxRates.mu.Lock()
+	fxRates.entries["USD/JPY"] = fxEntry{rate: 150, timestamp: time.Now()}
+	fxRates.mu.Unlock()
+	t.Cleanup(func() {
+		fxRates.mu.Lock()
+		delete(fxRates.entries, "USD/JPY")
+		fxRates.mu.Unlock()
+	})
+
+	// about ¥9,500,000, which is $63,000 before the conversion
+	up := &alertLevel{price: 9000000, up: true, known: true}
+	down := &alertLevel{price: 10000000, known: true, above: true}
+	if err := checkAlerts(context.Background(), bundb, cfg, []*alertLevel{up, down}); err != nil {
+		t.Fatal(err)
+	}
+	if !up.above {
+		t.Error("price wasn't converted before checking above the level")
+	}
+	if down.above {
+		t.Error("price wasn't converted before checking below the level")
+	}
+	if n := len(cfg.upload.(*stubUploader).formats); n != 2 {
+		t.Errorf("got %d alerts, want 2", n)
+	}
+}
+
+type failingUploader struct{}
+
+func (failingUploader) Upload(ctx context.Context, buf *bytes.Buffer, format string) (string, error) {
+	return "", errors.New("upload failed")
+}
+
+func TestCheckAlertsRetries(t *testing.T) {
+	bundb := newTestDB(t, 300)
+	cfg := newTestConfig(t)
+	up := cfg.upload
+	cfg.upload = failingUploader{}
+
+	// about ¥9,450,000 at the latest sample
+	level := &alertLevel{price: 9000000, up: true, known: true}
+	if err := checkAlerts(context.Background(), bundb, cfg, []*alertLevel{level}); err == nil {
+		t.Fatal("no error for a failed upload")
+	}
+	if level.above {
+		t.Fatal("the crossing was recorded although it wasn't announced")
+	}
+
+	cfg.upload = up
+	if err := checkAlerts(context.Background(), bundb, cfg, []*alertLevel{level}); err != nil {
+		t.Fatal(err)
+	}
+	if !level.above {
+		t.Error("the crossing wasn't recorded once announced")
+	}
+	if n := len(up.(*stubUploader).formats); n != 1 {
+		t.Errorf("got %d alerts, want 1", n)
+	}
+}
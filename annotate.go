@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/text"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// addHighLow marks the highest and lowest points with their value and time.
+// Labels are aligned towards the inside of the plot so they don't overflow
+// near the edges.
+func addHighLow(p *plot.Plot, points plotter.XYs, cur currency, clr color.Color, loc *time.Location) error {
+	if len(points) < 2 {
+		return nil
+	}
+	lo, hi := 0, 0
+	for i, pt := range points {
+		if pt.Y < points[lo].Y {
+			lo = i
+		}
+		if pt.Y > points[hi].Y {
+			hi = i
+		}
+	}
+	marks := plotter.XYs{points[hi], points[lo]}
+
+	scatter, err := plotter.NewScatter(marks)
+	if err != nil {
+		return err
+	}
+	scatter.GlyphStyle.Color = clr
+	scatter.GlyphStyle.Shape = draw.CircleGlyph{}
+	scatter.GlyphStyle.Radius = vg.Points(2)
+	p.Add(scatter)
+
+	xmid := (points[0].X + points[len(points)-1].X) / 2
+	var texts []string
+	for _, m := range marks {
+		texts = append(texts, fmt.Sprintf("%s (%s)", cur.format(m.Y), time.Unix(int64(m.X), 0).In(loc).Format("01/02 15:04")))
+	}
+	labels, err := plotter.NewLabels(plotter.XYLabels{XYs: marks, Labels: texts})
+	if err != nil {
+		return err
+	}
+	for i, m := range marks {
+		sty := &labels.TextStyle[i]
+		sty.Color = clr
+		sty.Font.Size = vg.Points(8)
+		if m.X > xmid {
+			sty.XAlign = text.XRight
+		}
+		if i == 0 {
+			sty.YAlign = text.YTop
+		}
+	}
+	p.Add(labels)
+	return nil
+}
+
+// addCrosshair draws a faint vertical line at the latest sample and a
+// dashed horizontal line at the latest price.
+func addCrosshair(p *plot.Plot, points plotter.XYs, cur currency, clr color.Color) error {
+	if len(points) < 2 {
+		return nil
+	}
+	last := points[len(points)-1]
+	ymin, ymax := last.Y, last.Y
+	for _, pt := range points {
+		ymin, ymax = math.Min(ymin, pt.Y), math.Max(ymax, pt.Y)
+	}
+	r, g, b, _ := clr.RGBA()
+	faint := color.NRGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 96}
+
+	vline, err := plotter.NewLine(plotter.XYs{{X: last.X, Y: ymin}, {X: last.X, Y: ymax}})
+	if err != nil {
+		return err
+	}
+	vline.Color = faint
+	vline.Width = vg.Points(0.5)
+	p.Add(vline)
+
+	hline, err := plotter.NewLine(plotter.XYs{{X: points[0].X, Y: last.Y}, {X: last.X, Y: last.Y}})
+	if err != nil {
+		return err
+	}
+	hline.Color = faint
+	hline.Width = vg.Points(0.5)
+	hline.Dashes = []vg.Length{vg.Points(3), vg.Points(3)}
+	p.Add(hline)
+
+	labels, err := plotter.NewLabels(plotter.XYLabels{
+		XYs:    plotter.XYs{{X: points[0].X, Y: last.Y}},
+		Labels: []string{cur.format(last.Y)},
+	})
+	if err != nil {
+		return err
+	}
+	labels.TextStyle[0].Color = faint
+	labels.TextStyle[0].Font.Size = vg.Points(8)
+	labels.Offset = vg.Point{X: vg.Points(2), Y: vg.Points(2)}
+	p.Add(labels)
+	return nil
+}
+
+type legendEntry struct {
+	name  string
+	thumb plot.Thumbnailer
+}
+
+// legendCorner picks the corner of the plot with the fewest points so the
+// legend doesn't hide the data. Ties prefer the top left.
+func legendCorner(points plotter.XYs) (top, left bool) {
+	xmin, xmax, ymin, ymax := plotter.XYRange(points)
+	if xmax == xmin || ymax == ymin {
+		return true, true
+	}
+	best := -1
+	for _, corner := range [][2]bool{{true, true}, {true, false}, {false, true}, {false, false}} {
+		n := 0
+		for _, pt := range points {
+			x := (pt.X - xmin) / (xmax - xmin)
+			y := (pt.Y - ymin) / (ymax - ymin)
+			if (corner[0] && y > 0.7 || !corner[0] && y < 0.3) && (corner[1] && x < 0.3 || !corner[1] && x > 0.7) {
+				n++
+			}
+		}
+		if best < 0 || n < best {
+			best, top, left = n, corner[0], corner[1]
+		}
+	}
+	return top, left
+}
+
+// annotation is a notable moment, like a halving, marked on the chart.
+type annotation struct {
+	Timestamp int64  `json:"timestamp"`
+	Label     string `json:"label"`
+}
+
+// annotations are marked on every chart whose window includes them.
+var annotations []annotation
+
+// loadAnnotations reads a JSON array of annotations from path.
+func loadAnnotations(path string) ([]annotation, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var notes []annotation
+	if err := json.Unmarshal(b, &notes); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return notes, nil
+}
+
+// addAnnotations draws a dashed vertical line with its label for each of
+// notes falling within points.
+func addAnnotations(p *plot.Plot, points plotter.XYs, notes []annotation, clr color.Color) error {
+	if len(points) < 2 {
+		return nil
+	}
+	xmin, xmax, ymin, ymax := plotter.XYRange(points)
+	xmid := (xmin + xmax) / 2
+
+	for _, n := range notes {
+		x := float64(n.Timestamp)
+		if x < xmin || x > xmax {
+			continue
+		}
+		line, err := plotter.NewLine(plotter.XYs{{X: x, Y: ymin}, {X: x, Y: ymax}})
+		if err != nil {
+			return err
+		}
+		line.Color = clr
+		line.Width = vg.Points(0.5)
+		line.Dashes = []vg.Length{vg.Points(2), vg.Points(2)}
+		p.Add(line)
+
+		labels, err := plotter.NewLabels(plotter.XYLabels{
+			XYs:    plotter.XYs{{X: x, Y: ymax}},
+			Labels: []string{n.Label},
+		})
+		if err != nil {
+			return err
+		}
+		sty := &labels.TextStyle[0]
+		sty.Color = clr
+		sty.Font.Size = vg.Points(7)
+		sty.YAlign = text.YTop
+		labels.Offset.X = vg.Points(2)
+		if x > xmid {
+			sty.XAlign = text.XRight
+			labels.Offset.X = -labels.Offset.X
+		}
+		p.Add(labels)
+	}
+	return nil
+}
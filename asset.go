@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/uptrace/bun"
+)
+
+const defaultAsset = "btc"
+
+// assets maps the asset keys accepted in requests to the tables holding
+// their BtcLog rows.
+var assets = map[string]string{
+	defaultAsset: "btclog",
+}
+
+// addAssets adds the assets in a comma separated list of key=table.
+func addAssets(s string) error {
+	for _, v := range splitList(s) {
+		key, table, ok := strings.Cut(v, "=")
+		if !ok || key == "" || table == "" {
+			return fmt.Errorf("invalid asset: %s (expected key=table)", v)
+		}
+		assets[strings.ToLower(key)] = table
+	}
+	return nil
+}
+
+// selectLogs returns a query selecting the BtcLog rows of asset.
+func selectLogs(bundb *bun.DB, asset string) *bun.SelectQuery {
+	return bundb.NewSelect().Model((*BtcLog)(nil)).ModelTableExpr("? AS f", bun.Ident(assets[asset]))
+}
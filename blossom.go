@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var blossomURL string
+
+// uploadBlossom stores data on a Blossom server (BUD-01/02) and returns the
+// URL of the blob.
+func uploadBlossom(ctx context.Context, server string, data []byte, format string, sign func(*nostr.Event) error) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	endpoint := strings.TrimSuffix(server, "/") + "/upload"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mimeTypes[format])
+
+	if sign != nil {
+		var ev nostr.Event
+		ev.Kind = 24242
+		ev.Content = "Upload chart." + format
+		ev.CreatedAt = nostr.Now()
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"t", "upload"})
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"x", hash})
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"expiration", strconv.FormatInt(time.Now().Add(5*time.Minute).Unix(), 10)})
+		err = sign(&ev)
+		if err != nil {
+			return "", err
+		}
+		b, err := ev.MarshalJSON()
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Nostr "+base64.StdEncoding.EncodeToString(b))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", &uploadError{err: err, retryable: true}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		b, _ := io.ReadAll(resp.Body)
+		reason := resp.Header.Get("X-Reason")
+		if reason == "" {
+			reason = string(b)
+		}
+		return "", &uploadError{
+			err:       fmt.Errorf("upload failed: %s: %s", resp.Status, reason),
+			retryable: resp.StatusCode >= 500,
+		}
+	}
+
+	var blob struct {
+		URL    string `json:"url"`
+		SHA256 string `json:"sha256"`
+		Size   int64  `json:"size"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&blob)
+	if err != nil {
+		return "", err
+	}
+	if blob.SHA256 != hash {
+		return "", fmt.Errorf("hash mismatch: sent %s but server stored %s", hash, blob.SHA256)
+	}
+	return blob.URL, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheKey struct {
+	span int
+	opts chartOptions
+}
+
+type cacheEntry struct {
+	img       chartImage
+	timestamp time.Time
+}
+
+type chartCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[cacheKey]cacheEntry
+}
+
+func newChartCache(ttl time.Duration) *chartCache {
+	return &chartCache{
+		ttl:     ttl,
+		entries: map[cacheKey]cacheEntry{},
+	}
+}
+
+func (c *chartCache) get(span int, opts chartOptions) (chartImage, bool) {
+	if c.ttl <= 0 {
+		return chartImage{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[cacheKey{span, opts}]
+	if !ok || time.Since(e.timestamp) > c.ttl {
+		return chartImage{}, false
+	}
+	return e.img, true
+}
+
+func (c *chartCache) put(span int, opts chartOptions, img chartImage) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.Sub(e.timestamp) > c.ttl {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[cacheKey{span, opts}] = cacheEntry{img: img, timestamp: now}
+}
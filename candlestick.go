@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+type Candle struct {
+	Time  float64
+	Open  float64
+	High  float64
+	Low   float64
+	Close float64
+}
+
+type Candlesticks struct {
+	Candles   []Candle
+	Width     float64
+	UpColor   color.Color
+	DownColor color.Color
+	LineWidth vg.Length
+}
+
+// newCandles buckets points into candles of interval seconds. ok is false
+// when the buckets hold too few points on average to form a candle.
+func newCandles(points plotter.XYs, interval float64) (candles []Candle, ok bool) {
+	if len(points) == 0 || interval <= 0 {
+		return nil, false
+	}
+	var cur *Candle
+	for _, pt := range points {
+		t := math.Floor(pt.X/interval) * interval
+		if cur == nil || cur.Time != t {
+			candles = append(candles, Candle{Time: t, Open: pt.Y, High: pt.Y, Low: pt.Y, Close: pt.Y})
+			cur = &candles[len(candles)-1]
+			continue
+		}
+		cur.High = math.Max(cur.High, pt.Y)
+		cur.Low = math.Min(cur.Low, pt.Y)
+		cur.Close = pt.Y
+	}
+	return candles, len(points) >= 2*len(candles)
+}
+
+func NewCandlesticks(candles []Candle, interval float64) *Candlesticks {
+	return &Candlesticks{
+		Candles:   candles,
+		Width:     interval * 0.7,
+		UpColor:   color.RGBA{R: 50, G: 255, B: 100, A: 255},
+		DownColor: color.RGBA{R: 255, G: 60, B: 60, A: 255},
+		LineWidth: vg.Points(1),
+	}
+}
+
+func (cs *Candlesticks) Plot(c draw.Canvas, plt *plot.Plot) {
+	trX, trY := plt.Transforms(&c)
+	for _, cd := range cs.Candles {
+		clr := cs.UpColor
+		if cd.Close < cd.Open {
+			clr = cs.DownColor
+		}
+		x := trX(cd.Time + cs.Width/2)
+		c.StrokeLine2(draw.LineStyle{Color: clr, Width: cs.LineWidth}, x, trY(cd.Low), x, trY(cd.High))
+
+		x0, x1 := trX(cd.Time), trX(cd.Time+cs.Width)
+		y0, y1 := trY(cd.Open), trY(cd.Close)
+		if y0 == y1 {
+			c.StrokeLine2(draw.LineStyle{Color: clr, Width: cs.LineWidth}, x0, y0, x1, y1)
+			continue
+		}
+		c.FillPolygon(clr, c.ClipPolygonXY([]vg.Point{{X: x0, Y: y0}, {X: x1, Y: y0}, {X: x1, Y: y1}, {X: x0, Y: y1}}))
+	}
+}
+
+func (cs *Candlesticks) DataRange() (xmin, xmax, ymin, ymax float64) {
+	xmin, xmax = math.Inf(1), math.Inf(-1)
+	ymin, ymax = math.Inf(1), math.Inf(-1)
+	for _, cd := range cs.Candles {
+		xmin = math.Min(xmin, cd.Time)
+		xmax = math.Max(xmax, cd.Time+cs.Width)
+		ymin = math.Min(ymin, cd.Low)
+		ymax = math.Max(ymax, cd.High)
+	}
+	return xmin, xmax, ymin, ymax
+}
+
+func (cs *Candlesticks) Thumbnail(c *draw.Canvas) {
+	pts := []vg.Point{
+		{X: c.Min.X, Y: c.Min.Y},
+		{X: c.Min.X, Y: c.Max.Y},
+		{X: c.Max.X, Y: c.Max.Y},
+		{X: c.Max.X, Y: c.Min.Y},
+	}
+	c.FillPolygon(cs.UpColor, c.ClipPolygonY(pts))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/uptrace/bun"
+	"gonum.org/v1/plot/plotter"
+)
+
+// comparePoints fetches the span minutes of rows ending opts.Compare before
+// the latest point, then shifts them forward by opts.Compare and scales
+// them so they start at the same price as points.
+func comparePoints(ctx context.Context, bundb *bun.DB, points plotter.XYs, span int, opts chartOptions) (plotter.XYs, error) {
+	offset, field := opts.Compare, opts.Field
+	last := int64(points[len(points)-1].X)
+	shift := int64(offset / time.Second)
+
+	var data []BtcLog
+	q := selectLogs(bundb, opts.asset()).Where("timestamp <= ? AND timestamp > ?", last-shift, last-shift-int64(span)*60)
+	err := limitSpan(q, span).Scan(ctx, &data)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].Timestamp < data[j].Timestamp
+	})
+	if len(data) == 0 || data[0].price(field) == 0 {
+		return nil, nil
+	}
+
+	scale := points[0].Y / data[0].price(field)
+	var result plotter.XYs
+	for _, d := range data {
+		result = append(result, plotter.XY{
+			X: float64(d.Timestamp + shift),
+			Y: d.price(field) * scale,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig reads a YAML file mapping flag names to values, plus nsec.
+// Lists like relays are joined with commas.
+func loadConfig(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	conf := map[string]string{}
+	for k, v := range raw {
+		if list, ok := v.([]any); ok {
+			var s []string
+			for _, e := range list {
+				s = append(s, fmt.Sprint(e))
+			}
+			conf[k] = strings.Join(s, ",")
+		} else {
+			conf[k] = fmt.Sprint(v)
+		}
+	}
+	return conf, nil
+}
+
+// envFlags maps the flags that default to an environment variable to the
+// variable.
+var envFlags = map[string]string{
+	"dsn":        "DATABASE_URL",
+	"auth-token": "BTCCHART_AUTH_TOKEN",
+}
+
+// applyConfig sets the flags in conf that weren't given on the command
+// line nor in their environment variable.
+func applyConfig(fs *flag.FlagSet, conf map[string]string) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for k, v := range conf {
+		if k == "nsec" || given[k] {
+			continue
+		}
+		if env, ok := envFlags[k]; ok && os.Getenv(env) != "" {
+			continue
+		}
+		if fs.Lookup(k) == nil {
+			return fmt.Errorf("unknown config key: %s", k)
+		}
+		if err := fs.Set(k, v); err != nil {
+			return fmt.Errorf("config key %s: %w", k, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+func TestApplyConfig(t *testing.T) {
+	conf := map[string]string{"dsn": "sqlite://config.db", "auth-token": "config", "span": "6h"}
+	newFlagSet := func() (*flag.FlagSet, *string, *string, *string) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		dsn := fs.String("dsn", os.Getenv(envFlags["dsn"]), "")
+		token := fs.String("auth-token", os.Getenv(envFlags["auth-token"]), "")
+		span := fs.String("span", "3h", "")
+		return fs, dsn, token, span
+	}
+
+	// the config file fills in what isn't set elsewhere
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("BTCCHART_AUTH_TOKEN", "")
+	fs, dsn, token, span := newFlagSet()
+	if err := applyConfig(fs, conf); err != nil {
+		t.Fatal(err)
+	}
+	if *dsn != "sqlite://config.db" || *token != "config" || *span != "6h" {
+		t.Errorf("got dsn=%q auth-token=%q span=%q, want the config values", *dsn, *token, *span)
+	}
+
+	// the environment wins over the config file
+	t.Setenv("DATABASE_URL", "sqlite://env.db")
+	t.Setenv("BTCCHART_AUTH_TOKEN", "env")
+	fs, dsn, token, _ = newFlagSet()
+	if err := applyConfig(fs, conf); err != nil {
+		t.Fatal(err)
+	}
+	if *dsn != "sqlite://env.db" || *token != "env" {
+		t.Errorf("got dsn=%q auth-token=%q, want the environment values", *dsn, *token)
+	}
+
+	// and flags win over both
+	fs, dsn, token, span = newFlagSet()
+	if err := fs.Parse([]string{"-dsn", "sqlite://flag.db", "-auth-token", "flag", "-span", "1h"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(fs, conf); err != nil {
+		t.Fatal(err)
+	}
+	if *dsn != "sqlite://flag.db" || *token != "flag" || *span != "1h" {
+		t.Errorf("got dsn=%q auth-token=%q span=%q, want the flag values", *dsn, *token, *span)
+	}
+
+	if err := applyConfig(fs, map[string]string{"nosuch": "1"}); err == nil {
+		t.Error("unknown key was accepted")
+	}
+}
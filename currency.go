@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/dustin/go-humanize"
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot"
+)
+
+type currency struct {
+	Code     string
+	Symbol   string
+	Decimals int
+}
+
+var currencies = map[string]currency{
+	"JPY": {Code: "JPY", Symbol: "¥", Decimals: 0},
+	"USD": {Code: "USD", Symbol: "$", Decimals: 2},
+	"EUR": {Code: "EUR", Symbol: "€", Decimals: 2},
+	"GBP": {Code: "GBP", Symbol: "£", Decimals: 2},
+	"KRW": {Code: "KRW", Symbol: "₩", Decimals: 0},
+}
+
+func (c currency) format(v float64) string {
+	if c.Decimals == 0 {
+		return humanize.Comma(int64(v))
+	}
+	return humanize.FormatFloat("#,###.##", v)
+}
+
+// priceTicks labels the ticks of hplot.Ticks with thousands separators, or
+// abbreviated like 9.5M when Abbreviate is set.
+type priceTicks struct {
+	Currency   currency
+	Abbreviate bool
+}
+
+func (t priceTicks) Ticks(min, max float64) []plot.Tick {
+	ticks := hplot.Ticks{N: 10}.Ticks(min, max)
+	var major []float64
+	for _, tick := range ticks {
+		if tick.Label != "" {
+			major = append(major, tick.Value)
+		}
+	}
+	step := math.Abs(max - min)
+	if len(major) > 1 {
+		step = major[1] - major[0]
+	}
+	for i := range ticks {
+		if ticks[i].Label == "" {
+			continue
+		}
+		if t.Abbreviate {
+			ticks[i].Label = abbreviate(ticks[i].Value, step)
+		} else {
+			ticks[i].Label = t.Currency.format(ticks[i].Value)
+		}
+	}
+	return ticks
+}
+
+// abbreviate formats v like 9.52M, with as many decimals as needed to tell
+// apart ticks that are step apart.
+func abbreviate(v, step float64) string {
+	for _, u := range []struct {
+		v float64
+		s string
+	}{{1e12, "T"}, {1e9, "B"}, {1e6, "M"}, {1e3, "K"}} {
+		if math.Abs(v) >= u.v {
+			decimals := 0
+			if step > 0 && step < u.v {
+				decimals = int(math.Ceil(-math.Log10(step / u.v)))
+			}
+			return strconv.FormatFloat(v/u.v, 'f', decimals, 64) + u.s
+		}
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"gonum.org/v1/plot/plotter"
+)
+
+// splitDirection splits points into runs that only rise or only fall, and
+// reports for each whether it rises. Consecutive runs share their end
+// point so the line stays connected, and flat steps belong to the run
+// they are in.
+func splitDirection(points plotter.XYs) (runs []plotter.XYs, up []bool) {
+	if len(points) < 2 {
+		return []plotter.XYs{points}, []bool{true}
+	}
+	start := 0
+	rising := true
+	for i := 1; i < len(points); i++ {
+		if points[i].Y != points[i-1].Y {
+			rising = points[i].Y > points[i-1].Y
+			break
+		}
+	}
+	for i := 1; i < len(points); i++ {
+		dy := points[i].Y - points[i-1].Y
+		if dy == 0 || (dy > 0) == rising {
+			continue
+		}
+		runs, up = append(runs, points[start:i]), append(up, rising)
+		start, rising = i-1, dy > 0
+	}
+	return append(runs, points[start:]), append(up, rising)
+}
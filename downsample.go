@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+const downsampleTarget = 1000
+
+// downsample reduces points to target points using the
+// Largest-Triangle-Three-Buckets algorithm, which keeps the visual shape
+// of the series. The first and last points are always kept.
+func downsample(points plotter.XYs, target int) plotter.XYs {
+	if target < 3 || len(points) <= target {
+		return points
+	}
+
+	result := make(plotter.XYs, 0, target)
+	result = append(result, points[0])
+
+	size := float64(len(points)-2) / float64(target-2)
+	a := 0
+	for i := 0; i < target-2; i++ {
+		// average of the next bucket is the third vertex of the triangle
+		next0 := int(float64(i+1)*size) + 1
+		next1 := min(int(float64(i+2)*size)+1, len(points))
+		var avgX, avgY float64
+		for _, pt := range points[next0:next1] {
+			avgX += pt.X
+			avgY += pt.Y
+		}
+		avgX /= float64(next1 - next0)
+		avgY /= float64(next1 - next0)
+
+		cur0 := int(float64(i)*size) + 1
+		cur1 := int(float64(i+1)*size) + 1
+		best, area := cur0, -1.0
+		for j := cur0; j < cur1; j++ {
+			s := math.Abs((points[a].X-avgX)*(points[j].Y-points[a].Y) - (points[a].X-points[j].X)*(avgY-points[a].Y))
+			if s > area {
+				best, area = j, s
+			}
+		}
+		result = append(result, points[best])
+		a = best
+	}
+
+	return append(result, points[len(points)-1])
+}
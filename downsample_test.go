@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// testSeries returns n points of a sine wave with a spike up at n/3 and
+// one down at 2n/3.
+func testSeries(n int) plotter.XYs {
+	points := make(plotter.XYs, n)
+	for i := range points {
+		points[i].X = float64(i * 60)
+		points[i].Y = 1000 + 100*math.Sin(float64(i)/200)
+	}
+	points[n/3].Y = 2000
+	points[2*n/3].Y = 0
+	return points
+}
+
+func TestDownsample(t *testing.T) {
+	points := testSeries(43200)
+	got := downsample(points, downsampleTarget)
+
+	if len(got) != downsampleTarget {
+		t.Fatalf("got %d points, want %d", len(got), downsampleTarget)
+	}
+	if got[0] != points[0] {
+		t.Errorf("first point = %v, want %v", got[0], points[0])
+	}
+	if got[len(got)-1] != points[len(points)-1] {
+		t.Errorf("last point = %v, want %v", got[len(got)-1], points[len(points)-1])
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].X <= got[i-1].X {
+			t.Fatalf("points are out of order at %d: %v after %v", i, got[i], got[i-1])
+		}
+	}
+
+	// the spikes are the extrema and must survive
+	for _, want := range []plotter.XY{points[len(points)/3], points[2*len(points)/3]} {
+		found := false
+		for _, pt := range got {
+			found = found || pt == want
+		}
+		if !found {
+			t.Errorf("extremum %v was dropped", want)
+		}
+	}
+}
+
+func TestDownsampleShort(t *testing.T) {
+	points := testSeries(downsampleTarget)
+	got := downsample(points, downsampleTarget)
+	if len(got) != len(points) || &got[0] != &points[0] {
+		t.Errorf("a series of %d points was not passed through", len(points))
+	}
+
+	points = testSeries(10)
+	if got := downsample(points, 2); len(got) != len(points) {
+		t.Errorf("target 2: got %d points, want %d", len(got), len(points))
+	}
+}
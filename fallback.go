@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fallbackTTL is how long charts are served by fallbackUploader.
+const fallbackTTL = 24 * time.Hour
+
+type storedChart struct {
+	data      []byte
+	format    string
+	timestamp time.Time
+}
+
+// fallbackUploader uploads with primary and, when that fails, keeps the
+// chart in memory and returns a URL under baseURL where ServeHTTP serves
+// it, so that the reply still has a chart while the image host is down.
+type fallbackUploader struct {
+	primary Uploader
+	baseURL string
+
+	mu     sync.Mutex
+	charts map[string]storedChart
+}
+
+func newFallbackUploader(primary Uploader, baseURL string) *fallbackUploader {
+	return &fallbackUploader{
+		primary: primary,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		charts:  map[string]storedChart{},
+	}
+}
+
+func (u *fallbackUploader) Upload(ctx context.Context, buf *bytes.Buffer, format string) (string, error) {
+	b := append([]byte(nil), buf.Bytes()...)
+	url, err := u.primary.Upload(ctx, buf, format)
+	if err == nil {
+		return url, nil
+	}
+
+	sum := sha256.Sum256(b)
+	name := hex.EncodeToString(sum[:]) + "." + format
+	slog.Warn("upload failed, serving the chart directly", "name", name, "error", err)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	for k, c := range u.charts {
+		if now.Sub(c.timestamp) > fallbackTTL {
+			delete(u.charts, k)
+		}
+	}
+	u.charts[name] = storedChart{data: b, format: format, timestamp: now}
+	return u.baseURL + "/charts/" + name, nil
+}
+
+func (u *fallbackUploader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	c, ok := u.charts[path.Base(r.URL.Path)]
+	u.mu.Unlock()
+	if !ok || time.Since(c.timestamp) > fallbackTTL {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("content-type", mimeTypes[c.format])
+	w.Write(c.data)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/font/opentype"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/font"
+	"gonum.org/v1/plot/plotter"
+)
+
+// loadFont registers the TrueType or OpenType font at path, the first face
+// of it for collections like NotoSansCJK.ttc, and makes it the font of all
+// the chart text. Without it gonum's bundled Liberation Serif is used,
+// which has no CJK glyphs.
+func loadFont(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	face, err := opentype.Parse(b)
+	if err != nil {
+		coll, cerr := opentype.ParseCollection(b)
+		if cerr != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if face, err = coll.Font(0); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	fnt := font.Font{Typeface: font.Typeface(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))}
+	font.DefaultCache.Add(font.Collection{{Font: fnt, Face: face}})
+	// plotter copies plot.DefaultFont at init, so both need setting
+	plot.DefaultFont = fnt
+	plotter.DefaultFont = fnt
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fxURL is the endpoint to fetch exchange rates from. {base} and {quote}
+// are replaced with the currency codes, and the response is expected to
+// hold the rate in rates.<quote>.
+var fxURL = "https://api.frankfurter.app/latest?from={base}&to={quote}"
+
+// fxTTL is how long a fetched rate is reused.
+const fxTTL = 10 * time.Minute
+
+type fxEntry struct {
+	rate      float64
+	timestamp time.Time
+}
+
+// fxCache remembers recently fetched exchange rates.
+type fxCache struct {
+	mu      sync.Mutex
+	entries map[string]fxEntry
+}
+
+var fxRates = &fxCache{entries: map[string]fxEntry{}}
+
+// rate returns how many of quote one base is worth.
+func (c *fxCache) rate(ctx context.Context, base, quote string) (float64, error) {
+	key := base + "/" + quote
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Since(e.timestamp) < fxTTL {
+		return e.rate, nil
+	}
+
+	rate, err := fetchRate(ctx, base, quote)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	c.entries[key] = fxEntry{rate: rate, timestamp: time.Now()}
+	c.mu.Unlock()
+	return rate, nil
+}
+
+func fetchRate(ctx context.Context, base, quote string) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	url := strings.NewReplacer("{base}", base, "{quote}", quote).Replace(fxURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	var result struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return 0, err
+	}
+	rate := result.Rates[quote]
+	if rate <= 0 {
+		return 0, fmt.Errorf("%s: no rate for %s", url, quote)
+	}
+	return rate, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"sort"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// splitGaps splits points wherever two consecutive samples are more than
+// factor times the median sample interval apart, so that missing data is
+// not drawn as a straight segment. factor of 0 disables splitting.
+func splitGaps(points plotter.XYs, factor float64) []plotter.XYs {
+	if factor <= 0 || len(points) < 3 {
+		return []plotter.XYs{points}
+	}
+	deltas := make([]float64, len(points)-1)
+	for i := 1; i < len(points); i++ {
+		deltas[i-1] = points[i].X - points[i-1].X
+	}
+	sort.Float64s(deltas)
+	threshold := deltas[len(deltas)/2] * factor
+
+	var segments []plotter.XYs
+	start := 0
+	for i := 1; i < len(points); i++ {
+		if points[i].X-points[i-1].X > threshold {
+			segments = append(segments, points[start:i])
+			start = i
+		}
+	}
+	return append(segments, points[start:])
+}
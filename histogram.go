@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// addHistogram draws how often each price level occurs in points, with the
+// price on the X axis and the number of samples on the Y axis.
+func addHistogram(p *plot.Plot, points plotter.XYs, opts chartOptions) error {
+	values := make(plotter.Values, len(points))
+	for i, pt := range points {
+		values[i] = pt.Y
+	}
+	// about the square root of the samples, within reason
+	bins := min(max(int(math.Sqrt(float64(len(values)))), 10), 50)
+	h, err := plotter.NewHist(values, bins)
+	if err != nil {
+		return err
+	}
+	h.FillColor = opts.Theme.Up
+	h.LineStyle = draw.LineStyle{Color: opts.Theme.Background, Width: vg.Points(0.5)}
+	p.Add(h)
+
+	p.X.Label.Text = opts.Currency.Code + "/" + strings.ToUpper(opts.asset())
+	p.X.Label.Position = draw.PosRight
+	p.X.Label.Padding = vg.Points(20)
+	p.X.LineStyle.Width = vg.Points(1)
+	p.X.Tick.Marker = priceTicks{Currency: opts.Currency, Abbreviate: opts.Abbreviate}
+	p.X.Tick.Label.Rotation = math.Pi / 3
+	p.X.Tick.Label.XAlign = -1.2
+	p.Y.Label.Text = "count"
+	p.Y.LineStyle.Width = vg.Points(1)
+	p.Y.Min = 0
+	return nil
+}
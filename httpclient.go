@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// httpClient is used for every outbound request: the ticker, FX rates and
+// uploads. main replaces it with one built from the flags.
+var httpClient = &http.Client{Timeout: time.Minute}
+
+// newHTTPClient returns a client giving up after timeout, going through
+// proxy, or the proxy of the environment (HTTPS_PROXY and so on) when it
+// is empty, and also trusting the PEM certificates in caFile unless it is
+// empty, e.g. for a TLS intercepting proxy.
+func newHTTPClient(timeout time.Duration, proxy, caFile string) (*http.Client, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		tr.Proxy = http.ProxyURL(u)
+	}
+	if caFile != "" {
+		b, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.New(caFile + ": no certificates found")
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Timeout: timeout, Transport: tr}, nil
+}
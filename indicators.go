@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// sma returns the simple moving average of points over n samples. The first
+// n-1 points are skipped since they don't have a full window yet.
+func sma(points plotter.XYs, n int) plotter.XYs {
+	if n < 1 || len(points) < n {
+		return nil
+	}
+	var result plotter.XYs
+	var sum float64
+	for i, pt := range points {
+		sum += pt.Y
+		if i >= n {
+			sum -= points[i-n].Y
+		}
+		if i >= n-1 {
+			result = append(result, plotter.XY{X: pt.X, Y: sum / float64(n)})
+		}
+	}
+	return result
+}
+
+// ema returns the exponential moving average of points with the smoothing
+// factor 2/(n+1). It is seeded with the simple average of the first n
+// points, so like sma the first n-1 points are skipped.
+func ema(points plotter.XYs, n int) plotter.XYs {
+	if n < 1 || len(points) < n {
+		return nil
+	}
+	alpha := 2 / float64(n+1)
+	var sum float64
+	for _, pt := range points[:n] {
+		sum += pt.Y
+	}
+	v := sum / float64(n)
+	result := plotter.XYs{{X: points[n-1].X, Y: v}}
+	for _, pt := range points[n:] {
+		v = alpha*pt.Y + (1-alpha)*v
+		result = append(result, plotter.XY{X: pt.X, Y: v})
+	}
+	return result
+}
+
+// bollinger returns the upper and lower Bollinger Bands, k standard
+// deviations above and below the simple moving average over n samples.
+func bollinger(points plotter.XYs, n int, k float64) (upper, lower plotter.XYs) {
+	mid := sma(points, n)
+	for i, m := range mid {
+		var sum float64
+		for _, pt := range points[i : i+n] {
+			sum += (pt.Y - m.Y) * (pt.Y - m.Y)
+		}
+		sd := math.Sqrt(sum / float64(n))
+		upper = append(upper, plotter.XY{X: m.X, Y: m.Y + k*sd})
+		lower = append(lower, plotter.XY{X: m.X, Y: m.Y - k*sd})
+	}
+	return upper, lower
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// ticker is the response of the ticker API. Coincheck returns numbers for
+// last/bid/ask and the unix time in timestamp.
+type ticker struct {
+	Last      float64 `json:"last"`
+	Bid       float64 `json:"bid"`
+	Ask       float64 `json:"ask"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+func fetchTicker(ctx context.Context, url string) (*ticker, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	var t ticker
+	err = json.NewDecoder(resp.Body).Decode(&t)
+	if err != nil {
+		return nil, err
+	}
+	if t.Last <= 0 || t.Bid <= 0 || t.Ask <= 0 {
+		return nil, fmt.Errorf("%s: invalid ticker: %+v", url, t)
+	}
+	return &t, nil
+}
+
+func ingestOnce(ctx context.Context, bundb *bun.DB, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	t, err := fetchTicker(ctx, url)
+	if err != nil {
+		return err
+	}
+	if t.Timestamp == 0 {
+		t.Timestamp = time.Now().Unix()
+	}
+	_, err = bundb.NewInsert().Model(&BtcLog{
+		Timestamp: t.Timestamp,
+		Last:      t.Last,
+		Bid:       t.Bid,
+		Ask:       t.Ask,
+		CreatedAt: time.Now(),
+	}).On("CONFLICT (timestamp) DO NOTHING").Exec(ctx)
+	return err
+}
+
+func ingest(ctx context.Context, bundb *bun.DB, url string, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		if err := ingestOnce(ctx, bundb, url); err != nil {
+			slog.Error("failed to ingest ticker", "url", url, "error", err)
+		}
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
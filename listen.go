@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/uptrace/bun"
+)
+
+// seenTTL is how long an event ID is remembered to avoid replying twice
+// to an event delivered by several relays or after a reconnection.
+const seenTTL = time.Hour
+
+type seenSet struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+// add reports whether id wasn't seen before and remembers it.
+func (s *seenSet) add(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, t := range s.ids {
+		if now.Sub(t) > seenTTL {
+			delete(s.ids, k)
+		}
+	}
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	s.ids[id] = now
+	return true
+}
+
+// listen subscribes to the events mentioning the bot on relays and replies
+// to the ones starting with command until ctx is done.
+func listen(ctx context.Context, bundb *bun.DB, cfg *handlerConfig, relays []string, command string) {
+	seen := &seenSet{ids: map[string]time.Time{}}
+	var wg sync.WaitGroup
+	for _, url := range relays {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			listenRelay(ctx, bundb, cfg, url, relays, command, seen)
+		}(url)
+	}
+	wg.Wait()
+}
+
+func listenRelay(ctx context.Context, bundb *bun.DB, cfg *handlerConfig, url string, relays []string, command string, seen *seenSet) {
+	var kinds []int
+	for k := range cfg.kinds {
+		kinds = append(kinds, k)
+	}
+	sort.Ints(kinds)
+
+	since := nostr.Now()
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := subscribe(ctx, url, nostr.Filter{
+			Kinds: kinds,
+			Tags:  nostr.TagMap{"p": []string{cfg.pub}},
+			Since: &since,
+		}, func(ev *nostr.Event) {
+			if ev.CreatedAt > since {
+				since = ev.CreatedAt
+			}
+			if !seen.add(ev.ID) {
+				return
+			}
+			if tok := strings.Fields(ev.Content); len(tok) == 0 || tok[0] != command {
+				return
+			}
+			reply(ctx, bundb, cfg, ev, relays)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		slog.Warn("relay subscription ended", "relay", url, "retry", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// subscribe calls f for each event matching filter on the relay at url
+// until the connection is lost.
+func subscribe(ctx context.Context, url string, filter nostr.Filter, f func(*nostr.Event)) error {
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+
+	sub, err := relay.Subscribe(ctx, nostr.Filters{filter})
+	if err != nil {
+		return err
+	}
+	defer sub.Unsub()
+
+	slog.Info("subscribed", "relay", url)
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return relay.Context().Err()
+			}
+			f(ev)
+		case <-relay.Context().Done():
+			return context.Cause(relay.Context())
+		}
+	}
+}
+
+func reply(ctx context.Context, bundb *bun.DB, cfg *handlerConfig, ev *nostr.Event, relays []string) {
+	eev, err := respond(ctx, bundb, cfg, ev)
+	if err != nil {
+		slog.Warn("failed to respond", "id", ev.ID, "pubkey", ev.PubKey, "error", err)
+		return
+	}
+	if len(cfg.relays) > 0 {
+		relays = cfg.relays
+	}
+	publish(ctx, relays, eev)
+}
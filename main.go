@@ -2,34 +2,44 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"image/color"
+	"io"
 	"log"
+	"log/slog"
 	"math"
 	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+	_ "time/tzdata"
+	"unicode"
+	"unicode/utf8"
 
-	"go-hep.org/x/hep/hplot"
-
-	"github.com/dustin/go-humanize"
 	_ "github.com/lib/pq"
-	"github.com/mattn/go-nostrbuild"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/vg"
 	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+	_ "modernc.org/sqlite"
 )
 
 const name = "nostr-btcchart"
@@ -38,6 +48,98 @@ const version = "0.0.25"
 
 var revision = "HEAD"
 
+var errNoData = errors.New("no price data for the requested span")
+
+// errYRange is returned when the Y bounds given leave no range to plot.
+var errYRange = errors.New("invalid Y range")
+
+// tooFewPointsError is returned when the span has fewer points than
+// chartOptions.MinPoints. It is an errNoData for callers.
+type tooFewPointsError struct {
+	n, min int
+}
+
+func (e *tooFewPointsError) Error() string {
+	return fmt.Sprintf("only %d price points for the requested span (at least %d needed)", e.n, e.min)
+}
+
+func (e *tooFewPointsError) Is(target error) bool {
+	return target == errNoData
+}
+
+const queryTimeout = 30 * time.Second
+
+const (
+	minSpan = 2 * time.Minute
+	maxSpan = 365 * 24 * time.Hour
+)
+
+// shortDuration formats d like 24h or 1h30m, without the zero units
+// time.Duration.String adds.
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// longUnits are the units parseLongDuration accepts on top of those of
+// time.ParseDuration. Months are 30 days and years 365 days.
+var longUnits = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+	"M": 30 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour,
+}
+
+// parseLongDuration parses a duration like time.ParseDuration, also
+// accepting whole days, weeks, months and years such as 1d, 2w, 3M and 1y.
+func parseLongDuration(s string) (time.Duration, error) {
+	for suffix, unit := range longUnits {
+		if v, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// timeLayouts are the layouts accepted for absolute times.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// parseTime parses an absolute time like 2024-05-01 or 2024-05-01T09:00,
+// in loc unless it has a zone offset.
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s (like 2024-05-01 or 2024-05-01T09:00)", s)
+}
+
+func validateSpan(span time.Duration) error {
+	if span < minSpan || span > maxSpan {
+		return fmt.Errorf("span must be between %s and %s", shortDuration(minSpan), shortDuration(maxSpan))
+	}
+	return nil
+}
+
 type BtcLog struct {
 	bun.BaseModel `bun:"table:btclog,alias:f"`
 	Timestamp     int64     `bun:"timestamp,pk,notnull" json:"timestamp"`
@@ -47,19 +149,427 @@ type BtcLog struct {
 	CreatedAt     time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
 }
 
+// price returns the value of the field, which is one of last, bid and
+// ask.
+func (d BtcLog) price(field string) float64 {
+	switch field {
+	case "last":
+		return d.Last
+	case "bid":
+		return d.Bid
+	}
+	return d.Ask
+}
+
+type bbands struct {
+	N int
+	K float64
+}
+
+type chartOptions struct {
+	ChartType  string
+	Interval   time.Duration
+	Format     string
+	SMA        int
+	EMA        int
+	BBands     bbands
+	Spread     bool
+	Currency   currency
+	Base       currency
+	HighLow    bool
+	Width      vg.Length
+	Height     vg.Length
+	LogScale   bool
+	Volume     bool
+	Theme      theme
+	Abbreviate bool
+	Location   *time.Location
+	Crosshair  bool
+	Compare    time.Duration
+	Field      string
+	Title      string
+	Grid       string
+	MaxGap     float64
+	YMin       float64
+	YMax       float64
+	FixYMin    bool
+	FixYMax    bool
+	YPad       float64
+	Outliers   bool
+	Range      bool
+	Resample   time.Duration
+	Asset      string
+	Smooth     bool
+	Drawdown   bool
+	DPI        int
+	Since      int64
+	Until      int64
+	Watermark  string
+	LineWidth  vg.Length
+	Markers    bool
+	MinPoints  int
+	ASCII      bool
+	Direction  string
+}
+
+func (o chartOptions) direction() string {
+	if o.Direction == "" {
+		return "off"
+	}
+	return o.Direction
+}
+
+func (o chartOptions) lineWidth() vg.Length {
+	if o.LineWidth == 0 {
+		return plotter.DefaultLineStyle.Width
+	}
+	return o.LineWidth
+}
+
+func (o chartOptions) dpi() int {
+	if o.DPI == 0 {
+		return vgimg.DefaultDPI
+	}
+	return o.DPI
+}
+
+func (o chartOptions) asset() string {
+	if o.Asset == "" {
+		return defaultAsset
+	}
+	return o.Asset
+}
+
+// defaultTitle is the title template used when none is given.
+const defaultTitle = "₿ {symbol} {price} {change}"
+
+func (o chartOptions) title() string {
+	if o.Title == "" {
+		return defaultTitle
+	}
+	return o.Title
+}
+
+func (o chartOptions) grid() string {
+	if o.Grid == "" {
+		return "major"
+	}
+	return o.Grid
+}
+
+func (o chartOptions) field() string {
+	if o.Field == "" {
+		return "ask"
+	}
+	return o.Field
+}
+
+// window fills in the end of the absolute window when only one is given,
+// span from the other, and returns the span of the window. With no window
+// it returns span.
+func (o *chartOptions) window(span time.Duration) (time.Duration, error) {
+	switch {
+	case o.Since == 0 && o.Until == 0:
+		return span, nil
+	case o.Until == 0:
+		o.Until = o.Since + int64(span/time.Second)
+	case o.Since == 0:
+		o.Since = o.Until - int64(span/time.Second)
+	}
+	if o.Until <= o.Since {
+		return 0, errors.New("until must be after since")
+	}
+	return time.Duration(o.Until-o.Since) * time.Second, nil
+}
+
+func (o *chartOptions) set(key, value string) error {
+	switch key {
+	case "type":
+		switch value {
+		case "line", "candlestick", "histogram":
+			o.ChartType = value
+		default:
+			return fmt.Errorf("unknown chart type: %s", value)
+		}
+	case "interval":
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if d < time.Minute {
+			return errors.New("interval must be at least 1m")
+		}
+		o.Interval = d
+	case "format":
+		if _, ok := mimeTypes[value]; !ok {
+			return fmt.Errorf("unsupported format: %s (supported: png, svg, jpeg, pdf, webp)", value)
+		}
+		o.Format = value
+	case "sma":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 2 {
+			return fmt.Errorf("invalid sma window: %s", value)
+		}
+		o.SMA = n
+	case "ema":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 2 {
+			return fmt.Errorf("invalid ema window: %s", value)
+		}
+		o.EMA = n
+	case "bbands":
+		ns, ks, _ := strings.Cut(value, ",")
+		n, err := strconv.Atoi(ns)
+		if err != nil || n < 2 {
+			return fmt.Errorf("invalid bbands period: %s", value)
+		}
+		k := 2.0
+		if ks != "" {
+			k, err = strconv.ParseFloat(ks, 64)
+			if err != nil || k <= 0 {
+				return fmt.Errorf("invalid bbands multiplier: %s", value)
+			}
+		}
+		o.BBands = bbands{N: n, K: k}
+	case "spread":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid spread: %s", value)
+		}
+		o.Spread = b
+	case "highlow":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid highlow: %s", value)
+		}
+		o.HighLow = b
+	case "linewidth":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f <= 0 || f > 10 {
+			return fmt.Errorf("invalid linewidth: %s (points, up to 10)", value)
+		}
+		o.LineWidth = vg.Points(f)
+	case "direction":
+		switch value {
+		case "off", "net", "segments":
+			o.Direction = value
+		default:
+			return fmt.Errorf("unknown direction: %s (supported: off, net, segments)", value)
+		}
+	case "ascii":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid ascii: %s", value)
+		}
+		o.ASCII = b
+	case "markers":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid markers: %s", value)
+		}
+		o.Markers = b
+	case "since", "until":
+		t, err := parseTime(value, o.Location)
+		if err != nil {
+			return err
+		}
+		if key == "since" {
+			o.Since = t.Unix()
+		} else {
+			o.Until = t.Unix()
+		}
+	case "dpi":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 50 || n > 300 {
+			return fmt.Errorf("invalid dpi: %s (50-300)", value)
+		}
+		o.DPI = n
+	case "drawdown":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid drawdown: %s", value)
+		}
+		o.Drawdown = b
+	case "smooth":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid smooth: %s", value)
+		}
+		o.Smooth = b
+	case "asset":
+		value = strings.ToLower(value)
+		if _, ok := assets[value]; !ok {
+			return fmt.Errorf("unknown asset: %s", value)
+		}
+		o.Asset = value
+	case "resample":
+		d, err := parseLongDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid resample: %s", value)
+		}
+		o.Resample = d
+	case "range":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid range: %s", value)
+		}
+		o.Range = b
+	case "outliers":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid outliers: %s", value)
+		}
+		o.Outliers = b
+	case "log":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid log: %s", value)
+		}
+		o.LogScale = b
+	case "volume":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid volume: %s", value)
+		}
+		o.Volume = b
+	case "ymin", "ymax":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", key, value)
+		}
+		if key == "ymin" {
+			o.YMin, o.FixYMin = f, true
+		} else {
+			o.YMax, o.FixYMax = f, true
+		}
+	case "ypad":
+		f, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || f < 0 || f > 100 {
+			return fmt.Errorf("invalid ypad: %s (0-100%%)", value)
+		}
+		o.YPad = f
+	case "maxgap":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 {
+			return fmt.Errorf("invalid maxgap: %s", value)
+		}
+		o.MaxGap = f
+	case "grid":
+		switch value {
+		case "off", "major", "minor":
+			o.Grid = value
+		default:
+			return fmt.Errorf("unknown grid: %s (supported: off, major, minor)", value)
+		}
+	case "field":
+		switch value {
+		case "last", "bid", "ask":
+			o.Field = value
+		default:
+			return fmt.Errorf("unknown field: %s (supported: last, bid, ask)", value)
+		}
+	case "compare":
+		d, err := parseLongDuration(value)
+		if err != nil {
+			return err
+		}
+		if d < minSpan {
+			return fmt.Errorf("compare offset must be at least %v", minSpan)
+		}
+		o.Compare = d
+	case "crosshair":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid crosshair: %s", value)
+		}
+		o.Crosshair = b
+	case "abbreviate":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid abbreviate: %s", value)
+		}
+		o.Abbreviate = b
+	case "theme":
+		t, ok := themes[value]
+		if !ok {
+			return fmt.Errorf("unknown theme: %s", value)
+		}
+		o.Theme = t
+	case "width", "height":
+		l, err := parseLength(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		if key == "width" {
+			o.Width = l
+		} else {
+			o.Height = l
+		}
+	default:
+		return fmt.Errorf("unknown option: %s", key)
+	}
+	return nil
+}
+
+// parseLength parses a length such as "5in" or "800px". A bare
+// number is taken as inches. Pixels are at 96 dpi whatever the dpi option
+// is, so a higher dpi enlarges the image in pixels too.
+func parseLength(s string) (vg.Length, error) {
+	unit := vg.Inch
+	if v, ok := strings.CutSuffix(s, "px"); ok {
+		s, unit = v, vg.Inch/vgimg.DefaultDPI
+	} else if v, ok := strings.CutSuffix(s, "in"); ok {
+		s = v
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	l := vg.Length(f) * unit
+	if l < 2*vg.Inch || l > 20*vg.Inch {
+		return 0, errors.New("must be between 2in and 20in")
+	}
+	return l, nil
+}
+
 type XTicks struct {
-	Ticker plot.Ticker
-	Time   func(t float64) time.Time
+	Ticker   plot.Ticker
+	Time     func(t float64) time.Time
+	Location *time.Location
+	// Interval is the average number of seconds between samples, or 0
+	// if unknown.
+	Interval float64
+}
+
+// scale returns the span the tick granularity is chosen for. It is the
+// axis span, widened to the next granularity when samples are several
+// ticks apart so that most ticks don't fall between samples.
+func (t XTicks) scale(min, max float64) float64 {
+	scale := max - min
+	if t.Interval >= 30*60 {
+		// sparser than the 10 minute ticks
+		scale = math.Max(scale, 15000)
+	}
+	if t.Interval >= 6*60*60 {
+		// sparser than the hourly ticks
+		scale = math.Max(scale, 90000)
+	}
+	return scale
 }
 
 func (t XTicks) Ticks(min, max float64) []plot.Tick {
 	ticks := []plot.Tick{}
-	tmcur := time.Unix(int64(min), 0)
-	tmmax := time.Unix(int64(max), 0)
-	if max-min < 15000 {
+	loc := t.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	scale := t.scale(min, max)
+	tmcur := time.Unix(int64(min), 0).In(loc)
+	tmmax := time.Unix(int64(max), 0).In(loc)
+	if scale < 15000 {
 		tmcur = time.Date(tmcur.Year(), tmcur.Month(), tmcur.Day(), tmcur.Hour(), tmcur.Minute()-tmcur.Minute()%10, 0, 0, tmcur.Location())
 		tmmax = time.Date(tmmax.Year(), tmmax.Month(), tmmax.Day(), tmmax.Hour(), tmmax.Minute()-tmmax.Minute()%10, 0, 0, tmmax.Location())
-	} else if max-min < 90000 {
+	} else if scale < 90000 {
 		tmcur = time.Date(tmcur.Year(), tmcur.Month(), tmcur.Day(), tmcur.Hour(), 0, 0, 0, tmcur.Location())
 		tmmax = time.Date(tmmax.Year(), tmmax.Month(), tmmax.Day(), tmmax.Hour(), 0, 0, 0, tmmax.Location())
 	} else {
@@ -69,7 +579,7 @@ func (t XTicks) Ticks(min, max float64) []plot.Tick {
 	c := 0
 	for {
 		tick := plot.Tick{Value: float64(tmcur.Unix())}
-		switch delta := max - min; {
+		switch delta := scale; {
 		case delta < 864000:
 			// delta is less than 10 days
 			// - mayor: every day (min: 0, max: 10)
@@ -103,7 +613,7 @@ func (t XTicks) Ticks(min, max float64) []plot.Tick {
 			// mayor: on day 1 of every month (min: 5, max: 18)
 			// minor: on day 1 and 15 of every month (min: 11, max: 36)
 			if tmcur.Day() == 1 {
-				tick.Label = tmcur.Format("2004/01")
+				tick.Label = tmcur.Format("2006/01")
 			}
 			if tmcur.Day() == 1 || tmcur.Day() == 15 {
 				ticks = append(ticks, tick)
@@ -113,16 +623,16 @@ func (t XTicks) Ticks(min, max float64) []plot.Tick {
 			// mayor: on the 1st of january (min: 1, max: inf.)
 			// minor: on day 1 of every month (min: 17, max inf.)
 			if tmcur.Day() == 1 && tmcur.Month() == time.January {
-				tick.Label = tmcur.Format("2004/01")
+				tick.Label = tmcur.Format("2006/01")
 			}
 			if tmcur.Day() == 1 {
 				ticks = append(ticks, tick)
 			}
 		}
 		c = c + 1
-		if max-min < 15000 {
+		if scale < 15000 {
 			tmcur = tmcur.Add(10 * time.Minute)
-		} else if max-min < 87000 {
+		} else if scale < 90000 {
 			tmcur = tmcur.Add(1 * time.Hour)
 		} else {
 			tmcur = tmcur.AddDate(0, 0, 1)
@@ -134,163 +644,1079 @@ func (t XTicks) Ticks(min, max float64) []plot.Tick {
 	return ticks
 }
 
-func generate(bundb *bun.DB, span int, output string, sign func(*nostr.Event) error) (string, error) {
-	if span < 2 || span > 43200 {
-		return "", errors.New("invalid request")
+// percentChange returns the change from first to last in percent.
+func percentChange(first, last float64) float64 {
+	return (last - first) / first * 100
+}
+
+// formatChange formats pct like (+1.2%), without a sign when it rounds to
+// zero so that tiny drops don't show as -0.0%.
+func formatChange(pct float64) string {
+	if math.Abs(pct) < 0.05 {
+		return "(0.0%)"
+	}
+	return fmt.Sprintf("(%+.1f%%)", pct)
+}
+
+// chartTitle expands the title template of opts for points, and returns it
+// with the change over the span in percent.
+func chartTitle(points plotter.XYs, span int, opts chartOptions) (string, float64) {
+	var pct float64
+	var change string
+	if len(points) > 1 && points[0].Y != 0 {
+		pct = percentChange(points[0].Y, points[len(points)-1].Y)
+		change = formatChange(pct)
+	}
+	return strings.TrimSpace(strings.NewReplacer(
+		"{symbol}", opts.Currency.Symbol,
+		"{price}", opts.Currency.format(points[len(points)-1].Y),
+		"{change}", change,
+		"{span}", shortDuration(time.Duration(span)*time.Minute),
+		"{asset}", strings.ToUpper(opts.asset()),
+	).Replace(opts.title())), pct
+}
+
+// renderSlots bounds how many charts are fetched and drawn at once to cap
+// the database load, CPU and memory used by bursts of requests.
+var renderSlots = make(chan struct{}, runtime.NumCPU())
+
+// acquireRenderSlot waits for one of renderSlots, and returns the function
+// to release it with.
+func acquireRenderSlot(ctx context.Context) (func(), error) {
+	select {
+	case renderSlots <- struct{}{}:
+		return func() { <-renderSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// renderChart draws the chart of the latest span minutes and encodes it in
+// format.
+func renderChart(ctx context.Context, bundb *bun.DB, span int, opts chartOptions, format string) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	if err := renderTo(ctx, &buf, bundb, span, opts, format); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// limitSpan orders q from the latest row and caps it at maxListLimit rows,
+// averaging the rows into buckets when span minutes would hold more than
+// that at one a minute.
+func limitSpan(q *bun.SelectQuery, span int) *bun.SelectQuery {
+	if span > maxListLimit {
+		bucket := (span*60 + maxListLimit - 1) / maxListLimit
+		q = q.ColumnExpr("MIN(f.timestamp) AS timestamp, AVG(f.last) AS last, AVG(f.bid) AS bid, AVG(f.ask) AS ask").
+			GroupExpr("f.timestamp / ?", bucket)
 	}
-	var data []BtcLog
-	err := bundb.NewSelect().Model((*BtcLog)(nil)).Order("timestamp DESC").Limit(span).Scan(context.Background(), &data)
+	return q.Order("timestamp DESC").Limit(maxListLimit)
+}
+
+// fetchPoints fetches the rows of the latest span minutes, or of the
+// absolute window of opts, in ascending order along with the points of the
+// price field. opts.Currency is set back to opts.Base when the prices can't
+// be converted.
+func fetchPoints(ctx context.Context, bundb *bun.DB, span int, opts *chartOptions) (data []BtcLog, points plotter.XYs, err error) {
+	if err := validateSpan(time.Duration(span) * time.Minute); err != nil {
+		return nil, nil, err
+	}
+	// select by time rather than by count so that the span is right
+	// whatever the sample interval is
+	q := selectLogs(bundb, opts.asset())
+	if opts.Until != 0 {
+		q = q.Where("timestamp >= ? AND timestamp < ?", opts.Since, opts.Until)
+	} else {
+		q = q.Where("timestamp > (SELECT MAX(timestamp) FROM ?) - ?", bun.Ident(assets[opts.asset()]), span*60)
+	}
+	err = limitSpan(q, span).Scan(ctx, &data)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
 	sort.Slice(data, func(i, j int) bool {
 		return data[i].Timestamp < data[j].Timestamp
 	})
+	if opts.Outliers {
+		var dropped int
+		data, dropped = filterOutliers(data, opts.field())
+		if dropped > 0 {
+			slog.Info("dropped outliers", "span", span, "dropped", dropped)
+		}
+	}
+
+	if opts.Base.Code != "" && opts.Base != opts.Currency {
+		rate, err := fxRates.rate(ctx, opts.Base.Code, opts.Currency.Code)
+		if err != nil {
+			slog.Warn("failed to fetch fx rate, charting in the native currency", "base", opts.Base.Code, "quote", opts.Currency.Code, "error", err)
+			opts.Currency = opts.Base
+		} else {
+			for i := range data {
+				data[i].Last *= rate
+				data[i].Bid *= rate
+				data[i].Ask *= rate
+			}
+		}
+	}
 
-	var points plotter.XYs
 	for _, d := range data {
 		points = append(points, plotter.XY{
 			X: float64(d.Timestamp),
-			Y: d.Ask,
+			Y: d.price(opts.Field),
 		})
 	}
 
+	if len(points) == 0 {
+		return nil, nil, errNoData
+	}
+	if len(points) < opts.MinPoints {
+		return nil, nil, &tooFewPointsError{len(points), opts.MinPoints}
+	}
+	return data, points, nil
+}
+
+// renderTo is like renderChart but writes the encoded chart to w as it is
+// encoded instead of buffering it.
+func renderTo(ctx context.Context, w io.Writer, bundb *bun.DB, span int, opts chartOptions, format string) error {
+	release, err := acquireRenderSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	data, points, err := fetchPoints(ctx, bundb, span, &opts)
+	if err != nil {
+		return err
+	}
+	return drawPoints(ctx, w, bundb, data, points, span, opts, format)
+}
+
+// drawPoints draws the chart of data and its points as returned by
+// fetchPoints and writes it to w encoded in format. The caller holds a
+// render slot.
+func drawPoints(ctx context.Context, w io.Writer, bundb *bun.DB, data []BtcLog, points plotter.XYs, span int, opts chartOptions, format string) error {
+	var err error
+
+	th := opts.Theme
 	p := plot.New()
-	p.Title.TextStyle.Color = color.White
-	p.BackgroundColor = color.Black
-	p.Title.Text = fmt.Sprintf("₿ ¥ %s", humanize.Comma(int64(points[len(points)-1].Y)))
-	p.Add(plotter.NewGrid())
-
-	//p.X.Label.Text = "Time"
-	p.X.Color = color.White
-	p.X.Label.TextStyle.Color = color.White
+	th.apply(p)
+	var pct float64
+	p.Title.Text, pct = chartTitle(points, span, opts)
+	if pct > 0 {
+		p.Title.TextStyle.Color = th.Up
+	} else if pct < 0 {
+		p.Title.TextStyle.Color = th.Down
+	}
+	if g := th.grid(opts.grid()); g != nil {
+		p.Add(g)
+	}
+	if opts.ChartType == "histogram" {
+		if err := addHistogram(p, points, opts); err != nil {
+			return err
+		}
+		return drawChart(w, p, nil, opts, format, span, len(points))
+	}
+
+	if opts.Range {
+		const layout = "2006-01-02 15:04"
+		from := time.Unix(int64(points[0].X), 0).In(opts.Location)
+		to := time.Unix(int64(points[len(points)-1].X), 0).In(opts.Location)
+		p.X.Label.Text = from.Format(layout) + " — " + to.Format(layout+" MST")
+		p.X.Label.TextStyle.Font.Size = vg.Points(8)
+	}
 	p.X.Label.Padding = vg.Points(10)
-	p.X.LineStyle.Color = color.White
 	p.X.LineStyle.Width = vg.Points(1)
-	p.X.Tick.Color = color.White
-	p.X.Tick.Marker = XTicks{}
+	p.X.Tick.Marker = XTicks{
+		Location: opts.Location,
+		Interval: (points[len(points)-1].X - points[0].X) / float64(max(len(points)-1, 1)),
+	}
 	p.X.Tick.Label.Rotation = math.Pi / 3
 	p.X.Tick.Label.XAlign = -1.2
-	p.X.Tick.Label.Color = color.White
 
-	//p.Y.Label.Text = "JPY/BTC"
-	p.Y.Color = color.White
-	p.Y.Label.TextStyle.Color = color.White
-	p.Y.LineStyle.Color = color.White
+	p.Legend.TextStyle.Color = th.Foreground
+	p.Legend.TextStyle.Font.Size = vg.Points(8)
+
+	p.Y.Label.Text = opts.Currency.Code + "/" + strings.ToUpper(opts.asset())
 	p.Y.LineStyle.Width = vg.Points(1)
-	p.Y.Tick.Color = color.White
-	p.Y.Tick.Label.Color = color.White
-	p.Y.Tick.Marker = hplot.Ticks{
-		N:      10,
-		Format: "%.0f",
+	p.Y.Tick.Marker = priceTicks{Currency: opts.Currency, Abbreviate: opts.Abbreviate}
+	if opts.LogScale {
+		ymin, ymax := math.Inf(1), math.Inf(-1)
+		for _, pt := range points {
+			ymin, ymax = math.Min(ymin, pt.Y), math.Max(ymax, pt.Y)
+		}
+		if ymin <= 0 {
+			return errors.New("log scale requires positive prices")
+		}
+		p.Y.Scale = plot.LogScale{}
+		// LogTicks only labels powers of ten, so keep the linear ticks
+		// unless the range covers at least one decade.
+		if ymax/ymin >= 10 {
+			p.Y.Tick.Marker = plot.LogTicks{Prec: -1}
+		}
 	}
-	p.Y.Tick.Label.Color = color.White
 	p.Y.Label.Position = draw.PosRight
 	p.X.Label.Position = draw.PosTop
 
-	line, err := plotter.NewLine(points)
-	if err != nil {
-		log.Println(err)
-	}
-	line.Color = color.RGBA{R: 50, G: 255, B: 100, A: 255}
-	p.Add(line)
-
-	if output != "" {
-		err := p.Save(5*vg.Inch, 4*vg.Inch, output)
-		return "", err
-	}
-	var buf bytes.Buffer
-	w, err := p.WriterTo(5*vg.Inch, 4*vg.Inch, "png")
-	if err != nil {
-		return "", err
-	}
-	_, err = w.WriteTo(&buf)
-	if err != nil {
-		return "", err
+	var legend []legendEntry
+	if opts.Spread {
+		band := make(plotter.XYs, 0, 2*len(data))
+		for _, d := range data {
+			band = append(band, plotter.XY{X: float64(d.Timestamp), Y: d.Ask})
+		}
+		for i := len(data) - 1; i >= 0; i-- {
+			band = append(band, plotter.XY{X: float64(data[i].Timestamp), Y: data[i].Bid})
+		}
+		poly, err := plotter.NewPolygon(band)
+		if err != nil {
+			return err
+		}
+		poly.Color = color.NRGBA{R: 50, G: 160, B: 255, A: 96}
+		poly.LineStyle.Color = color.Transparent
+		p.Add(poly)
+		legend = append(legend, legendEntry{"spread", poly})
 	}
 
-	result, err := nostrbuild.Upload(&buf, sign)
-	if err != nil {
-		return "", err
+	if opts.Drawdown {
+		band := make(plotter.XYs, 0, 2*len(points))
+		peak := math.Inf(-1)
+		for _, pt := range points {
+			peak = math.Max(peak, pt.Y)
+			band = append(band, plotter.XY{X: pt.X, Y: peak})
+		}
+		for i := len(points) - 1; i >= 0; i-- {
+			band = append(band, points[i])
+		}
+		poly, err := plotter.NewPolygon(band)
+		if err != nil {
+			return err
+		}
+		poly.Color = color.NRGBA{R: 255, G: 60, B: 60, A: 64}
+		poly.LineStyle.Color = color.Transparent
+		p.Add(poly)
+		legend = append(legend, legendEntry{"drawdown", poly})
 	}
-	return result.Data[0].URL, nil
-}
 
-func handler(bundb *bun.DB, nsec string) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			w.Header().Set("content-type", "application/json")
-			var data []BtcLog
-			err := bundb.NewSelect().Model((*BtcLog)(nil)).Order("timestamp DESC").Limit(180).Scan(context.Background(), &data)
+	if upper, lower := bollinger(points, opts.BBands.N, opts.BBands.K); len(upper) > 1 {
+		band := append(plotter.XYs{}, upper...)
+		for i := len(lower) - 1; i >= 0; i-- {
+			band = append(band, lower[i])
+		}
+		poly, err := plotter.NewPolygon(band)
+		if err != nil {
+			return err
+		}
+		poly.Color = color.NRGBA{R: 150, G: 150, B: 255, A: 48}
+		poly.LineStyle.Color = color.Transparent
+		p.Add(poly)
+		for _, b := range []plotter.XYs{upper, lower} {
+			line, err := plotter.NewLine(b)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+				return err
 			}
-			json.NewEncoder(w).Encode(data)
-			return
+			line.Color = color.RGBA{R: 150, G: 150, B: 255, A: 255}
+			line.Dashes = []vg.Length{vg.Points(4), vg.Points(2)}
+			p.Add(line)
 		}
-		var ev nostr.Event
-		err := json.NewDecoder(r.Body).Decode(&ev)
+		legend = append(legend, legendEntry{fmt.Sprintf("BB(%d,%g)", opts.BBands.N, opts.BBands.K), poly})
+	}
+
+	var candles []Candle
+	interval := opts.Interval
+	if interval == 0 {
+		interval = max(time.Duration(span/30)*time.Minute, time.Minute)
+	}
+	if opts.ChartType == "candlestick" {
+		var ok bool
+		candles, ok = newCandles(points, interval.Seconds())
+		if !ok {
+			candles = nil
+		}
+	}
+	if opts.Compare > 0 {
+		prev, err := comparePoints(ctx, bundb, points, span, opts)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return err
 		}
-		tok := strings.Split(ev.Content, " ")
-		span := 180 * time.Minute
-		if len(tok) == 2 {
-			span, err = time.ParseDuration(tok[1])
+		if len(prev) > 1 {
+			line, err := plotter.NewLine(downsample(prev, downsampleTarget))
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+				return err
 			}
+			line.Color = color.RGBA{R: 120, G: 180, B: 255, A: 255}
+			p.Add(line)
+			legend = append(legend, legendEntry{"-" + shortDuration(opts.Compare), line})
 		}
+	}
 
-		eev := nostr.Event{}
-		var sk string
-		if _, s, err := nip19.Decode(nsec); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		} else {
-			sk = s.(string)
+	if candles != nil {
+		cs := NewCandlesticks(candles, interval.Seconds())
+		cs.UpColor, cs.DownColor = th.Up, th.Down
+		p.Add(cs)
+		legend = append([]legendEntry{{opts.field(), cs}}, legend...)
+	} else if len(points) == 1 {
+		// a line needs two points, so mark the only sample instead
+		scatter, err := plotter.NewScatter(points)
+		if err != nil {
+			return err
 		}
-		if pub, err := nostr.GetPublicKey(sk); err == nil {
-			if _, err := nip19.EncodePublicKey(pub); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+		scatter.GlyphStyle.Color = th.Up
+		scatter.GlyphStyle.Shape = draw.CircleGlyph{}
+		scatter.GlyphStyle.Radius = vg.Points(3)
+		p.Add(scatter)
+		legend = append([]legendEntry{{opts.field(), scatter}}, legend...)
+	} else {
+		price := resample(points, opts.Resample, opts.Location)
+		for i, seg := range splitGaps(price, opts.MaxGap) {
+			target := max(downsampleTarget*len(seg)/len(price), 3)
+			if opts.Smooth {
+				seg = smooth(seg, int(math.Ceil(float64(target)/float64(len(seg)))))
+			}
+			seg = downsample(seg, target)
+			runs, up := []plotter.XYs{seg}, []bool{true}
+			switch opts.direction() {
+			case "net":
+				up[0] = pct >= 0
+			case "segments":
+				runs, up = splitDirection(seg)
+			}
+			for j, run := range runs {
+				line, err := plotter.NewLine(run)
+				if err != nil {
+					slog.Error("failed to create price line", "span", span, "points", len(seg), "error", err)
+					return err
+				}
+				line.Color = th.Up
+				if !up[j] {
+					line.Color = th.Down
+				}
+				line.Width = opts.lineWidth()
+				p.Add(line)
+				if i == 0 && j == 0 {
+					legend = append([]legendEntry{{opts.field(), line}}, legend...)
+				}
 			}
-			eev.PubKey = pub
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-
-		sign := func(ev *nostr.Event) error {
-			ev.PubKey = eev.PubKey
-			return ev.Sign(sk)
+		if opts.Markers {
+			scatter, err := plotter.NewScatter(price)
+			if err != nil {
+				return err
+			}
+			scatter.GlyphStyle.Color = th.Up
+			scatter.GlyphStyle.Shape = draw.CircleGlyph{}
+			scatter.GlyphStyle.Radius = max(opts.lineWidth(), vg.Points(1))
+			p.Add(scatter)
 		}
+	}
 
-		img, err := generate(bundb, int(span/time.Minute), "", sign)
+	if avg := sma(points, opts.SMA); len(avg) > 1 {
+		line, err := plotter.NewLine(avg)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return err
 		}
+		line.Color = color.RGBA{R: 255, G: 165, B: 0, A: 255}
+		p.Add(line)
+		legend = append(legend, legendEntry{fmt.Sprintf("SMA(%d)", opts.SMA), line})
+	}
 
-		eev.Content = img + "\n#ビットコインチャート"
-		eev.CreatedAt = nostr.Now()
-		eev.Kind = ev.Kind
-		eev.Tags = eev.Tags.AppendUnique(nostr.Tag{"e", ev.ID, "", "root"})
-		eev.Tags = eev.Tags.AppendUnique(nostr.Tag{"p", ev.PubKey})
-		eev.Tags = eev.Tags.AppendUnique(nostr.Tag{"t", "ビットコインチャート"})
-		for _, te := range ev.Tags {
-			if te.Key() == "e" {
-				eev.Tags = eev.Tags.AppendUnique(te)
-			}
+	if avg := ema(points, opts.EMA); len(avg) > 1 {
+		line, err := plotter.NewLine(avg)
+		if err != nil {
+			return err
+		}
+		line.Color = color.RGBA{R: 255, G: 80, B: 255, A: 255}
+		p.Add(line)
+		legend = append(legend, legendEntry{fmt.Sprintf("EMA(%d)", opts.EMA), line})
+	}
+
+	for _, e := range legend {
+		p.Legend.Add(e.name, e.thumb)
+	}
+	p.Legend.Top, p.Legend.Left = legendCorner(points)
+
+	if opts.Crosshair {
+		if err := addCrosshair(p, points, opts.Currency, th.Foreground); err != nil {
+			return err
+		}
+	}
+	if err := addAnnotations(p, points, annotations, th.Foreground); err != nil {
+		return err
+	}
+	if opts.HighLow {
+		if err := addHighLow(p, points, opts.Currency, th.Foreground, opts.Location); err != nil {
+			return err
+		}
+	}
+
+	if p.X.Min == p.X.Max {
+		// center a single sample in the span
+		half := float64(span) * 60 / 2
+		p.X.Min, p.X.Max = p.X.Min-half, p.X.Max+half
+	}
+	if p.Y.Min == p.Y.Max {
+		pad := math.Max(math.Abs(p.Y.Min)/100, 1)
+		p.Y.Min, p.Y.Max = p.Y.Min-pad, p.Y.Max+pad
+	}
+	if opts.YPad > 0 {
+		pad := (p.Y.Max - p.Y.Min) * opts.YPad / 100
+		p.Y.Max += pad
+		if !opts.LogScale || p.Y.Min > pad {
+			p.Y.Min -= pad
+		}
+	}
+	if opts.FixYMin {
+		p.Y.Min = opts.YMin
+	}
+	if opts.FixYMax {
+		p.Y.Max = opts.YMax
+	}
+	if p.Y.Min >= p.Y.Max {
+		return fmt.Errorf("%w: %g to %g", errYRange, p.Y.Min, p.Y.Max)
+	}
+	if opts.LogScale && p.Y.Min <= 0 {
+		return fmt.Errorf("%w: log scale requires a positive Y minimum", errYRange)
+	}
+
+	var vp *plot.Plot
+	if opts.Volume {
+		vp, err = newVolumePlot(points, interval.Seconds(), th, opts.grid(), opts.Location)
+		if err != nil {
+			return err
+		}
+		vp.X.Min, vp.X.Max = p.X.Min, p.X.Max
+		vp.X.Label = p.X.Label
+		vp.X.Tick.Marker = p.X.Tick.Marker
+		p.X.Label.Text = ""
+		p.X.Tick.Marker = noLabels{p.X.Tick.Marker}
+	}
+
+	return drawChart(w, p, vp, opts, format, span, len(points))
+}
+
+// drawChart draws p, with the volume plot vp below it unless vp is nil, and
+// writes it to w encoded in format.
+func drawChart(w io.Writer, p, vp *plot.Plot, opts chartOptions, format string, span, n int) error {
+	start := time.Now()
+	c, err := newCanvas(opts.Width, opts.Height, format, opts.dpi())
+	if err != nil {
+		return err
+	}
+	dc := draw.New(c)
+	if opts.Watermark != "" {
+		dc = drawWatermark(dc, opts.Watermark, opts.Theme)
+	}
+	if vp != nil {
+		drawStacked(dc, p, vp, 0.25)
+	} else {
+		p.Draw(dc)
+	}
+	if _, err = c.WriteTo(w); err != nil {
+		return err
+	}
+	renderDuration.Observe(time.Since(start).Seconds())
+	slog.Debug("rendered chart", "span", span, "points", n, "format", format, "duration", time.Since(start))
+	return nil
+}
+
+func generate(ctx context.Context, bundb *bun.DB, span int, opts chartOptions, output string, up Uploader) (chartImage, error) {
+	format := opts.Format
+	if output != "" {
+		format = strings.ToLower(strings.TrimPrefix(filepath.Ext(output), "."))
+		if format == "" {
+			return chartImage{}, fmt.Errorf("%s: no file extension to tell the format from (supported: png, svg, jpeg, pdf, webp)", output)
+		}
+		if _, ok := mimeTypes[format]; !ok {
+			return chartImage{}, fmt.Errorf("%s: unsupported format: %s (supported: png, svg, jpeg, pdf, webp)", output, format)
+		}
+	}
+	buf, err := renderChart(ctx, bundb, span, opts, format)
+	if err != nil {
+		return chartImage{}, err
+	}
+	if output != "" {
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return chartImage{}, err
+		}
+		return chartImage{}, os.WriteFile(output, buf.Bytes(), 0644)
+	}
+	b := buf.Bytes()
+
+	url, err := up.Upload(ctx, buf, format)
+	if err != nil {
+		return chartImage{}, err
+	}
+	return newChartImage(url, b, format, pixels(opts.Width, opts.dpi()), pixels(opts.Height, opts.dpi())), nil
+}
+
+// pixels returns the size in pixels of l when rasterized at dpi.
+func pixels(l vg.Length, dpi int) int {
+	return int(math.Ceil(l.Dots(float64(dpi))))
+}
+
+func decodeKey(nsec string) (string, string, error) {
+	prefix, s, err := nip19.Decode(nsec)
+	if err != nil {
+		return "", "", err
+	}
+	sk, ok := s.(string)
+	if prefix != "nsec" || !ok {
+		return "", "", errors.New("invalid nsec")
+	}
+	pub, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := nip19.EncodePublicKey(pub); err != nil {
+		return "", "", err
+	}
+	return sk, pub, nil
+}
+
+type handlerConfig struct {
+	sk        string
+	pub       string
+	defaults  chartOptions
+	relays    []string
+	cache     *chartCache
+	limiter   *rateLimiter
+	upload    Uploader
+	kinds     map[int]bool
+	replyKind int
+	authToken string
+	listLimit int
+}
+
+// requestError is an error caused by the request, carrying the HTTP
+// status to answer with.
+type requestError struct {
+	err    error
+	status int
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+const (
+	// maxContentLength is the longest content of a request. Commands are
+	// a few words, so anything longer isn't one.
+	maxContentLength = 1024
+	// maxEventSize is the largest request body accepted by handler.
+	maxEventSize = 64 * 1024
+	// maxListLimit is the most rows a GET lists, a month of minute samples.
+	maxListLimit = 43200
+)
+
+// sanitize replaces invalid UTF-8 and unprintable characters in s so that
+// it is safe to parse and log.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsPrint(r) || unicode.IsSpace(r) {
+			return r
+		}
+		return utf8.RuneError
+	}, strings.ToValidUTF8(s, string(utf8.RuneError)))
+}
+
+// parseContent parses the tokens of a chart request like "chart 24h
+// candlestick sma=20" at any position and separated by any whitespace, and
+// returns the span and opts updated with the options of the request. The
+// first token is taken as the command word unless it is a span or an
+// option.
+func parseContent(content string, opts chartOptions) (time.Duration, chartOptions, error) {
+	span := 180 * time.Minute
+	var err error
+	var dates int
+	for i, t := range strings.Fields(content) {
+		if k, v, ok := strings.Cut(t, "="); ok {
+			err = opts.set(k, v)
+		} else if d, perr := parseLongDuration(t); perr == nil {
+			span = d
+		} else if i == 0 {
+			// the command word
+			continue
+		} else if _, ok := assets[strings.ToLower(t)]; ok {
+			err = opts.set("asset", t)
+		} else if t == "ascii" {
+			opts.ASCII = true
+		} else if _, perr := parseTime(t, opts.Location); perr == nil {
+			// since then until
+			if dates == 0 {
+				err = opts.set("since", t)
+			} else {
+				err = opts.set("until", t)
+			}
+			dates++
+		} else {
+			err = opts.set("format", t)
+		}
+		if err != nil {
+			return 0, opts, err
+		}
+	}
+	if opts.FixYMin && opts.FixYMax && opts.YMin >= opts.YMax {
+		return 0, opts, fmt.Errorf("%w: %g to %g", errYRange, opts.YMin, opts.YMax)
+	}
+	if opts.LogScale && opts.FixYMin && opts.YMin <= 0 {
+		return 0, opts, fmt.Errorf("%w: log scale requires a positive Y minimum", errYRange)
+	}
+	return span, opts, nil
+}
+
+// respond generates the chart requested by ev and returns the signed
+// reply to it.
+func respond(ctx context.Context, bundb *bun.DB, cfg *handlerConfig, ev *nostr.Event) (nostr.Event, error) {
+	if !cfg.kinds[ev.Kind] {
+		return nostr.Event{}, &requestError{fmt.Errorf("unsupported kind: %d", ev.Kind), http.StatusBadRequest}
+	}
+	if !cfg.limiter.allow(ev.PubKey) {
+		slog.Warn("rate limited", "id", ev.ID, "pubkey", ev.PubKey)
+		return nostr.Event{}, &requestError{errors.New("too many requests"), http.StatusTooManyRequests}
+	}
+	if len(ev.Content) > maxContentLength {
+		return nostr.Event{}, &requestError{fmt.Errorf("content too long: %d bytes (max %d)", len(ev.Content), maxContentLength), http.StatusBadRequest}
+	}
+	content := sanitize(ev.Content)
+	slog.Debug("chart request", "id", ev.ID, "pubkey", ev.PubKey, "content", content)
+
+	span, opts, err := parseContent(content, cfg.defaults)
+	if err != nil {
+		return nostr.Event{}, &requestError{err, http.StatusBadRequest}
+	}
+	if tag := ev.Tags.GetFirst([]string{"span", ""}); tag != nil {
+		span, err = parseLongDuration(tag.Value())
+		if err != nil {
+			return nostr.Event{}, &requestError{err, http.StatusBadRequest}
+		}
+	}
+	span, err = opts.window(span)
+	if err == nil {
+		err = validateSpan(span)
+	}
+	if err != nil {
+		return nostr.Event{}, &requestError{err, http.StatusBadRequest}
+	}
+
+	eev := nostr.Event{}
+	eev.PubKey = cfg.pub
+
+	chartRequests.Inc()
+	var text string
+	var imeta nostr.Tag
+	if opts.ASCII {
+		ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+		text, err = renderSparkline(ctx, bundb, int(span/time.Minute), opts)
+		cancel()
+		if errors.Is(err, errNoData) {
+			return nostr.Event{}, &requestError{err, http.StatusNotFound}
+		} else if err != nil {
+			generateFailures.Inc()
+			slog.Error("failed to generate sparkline", "id", ev.ID, "span", span, "error", err)
+			return nostr.Event{}, err
+		}
+	} else {
+		img, ok := cfg.cache.get(int(span/time.Minute), opts)
+		if !ok {
+			start := time.Now()
+			ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+			img, err = generate(ctx, bundb, int(span/time.Minute), opts, "", cfg.upload)
+			cancel()
+			if errors.Is(err, errNoData) {
+				return nostr.Event{}, &requestError{err, http.StatusNotFound}
+			} else if errors.Is(err, errYRange) {
+				// bounds that leave out the prices of the span
+				return nostr.Event{}, &requestError{err, http.StatusBadRequest}
+			} else if err != nil {
+				generateFailures.Inc()
+				slog.Error("failed to generate chart", "id", ev.ID, "span", span, "error", err)
+				return nostr.Event{}, err
+			}
+			slog.Info("generated chart", "id", ev.ID, "span", span, "duration", time.Since(start))
+			cfg.cache.put(int(span/time.Minute), opts, img)
+		}
+		text, imeta = img.URL, img.imeta()
+	}
+
+	eev.Content = text + "\n#ビットコインチャート"
+	eev.CreatedAt = nostr.Now()
+	eev.Kind = replyKind(ev.Kind, cfg.replyKind)
+	eev.Tags = replyTags(ev)
+	eev.Tags = eev.Tags.AppendUnique(nostr.Tag{"t", "ビットコインチャート"})
+	if imeta != nil {
+		eev.Tags = append(eev.Tags, imeta)
+	}
+	if err := eev.Sign(cfg.sk); err != nil {
+		return nostr.Event{}, err
+	}
+	return eev, nil
+}
+
+// textKinds are the kinds whose replies keep the kind of the request. Other
+// kinds, like replaceable events, aren't plain text and get a text note.
+var textKinds = map[int]bool{
+	nostr.KindTextNote:       true,
+	nostr.KindChannelMessage: true,
+}
+
+// replyKind returns the kind to reply to an event of kind with, which is
+// override unless it is 0.
+func replyKind(kind, override int) int {
+	if override != 0 {
+		return override
+	}
+	if textKinds[kind] {
+		return kind
+	}
+	return nostr.KindTextNote
+}
+
+func handler(bundb *bun.DB, cfg *handlerConfig) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodPost:
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Method != http.MethodPost {
+			limit := cfg.listLimit
+			asset := defaultAsset
+			if v := r.URL.Query().Get("asset"); v != "" {
+				asset = strings.ToLower(v)
+				if _, ok := assets[asset]; !ok {
+					http.Error(w, "unknown asset: "+v, http.StatusBadRequest)
+					return
+				}
+			}
+			q := selectLogs(bundb, asset).Order("timestamp DESC")
+			if v := r.URL.Query().Get("span"); v != "" {
+				span, err := parseLongDuration(v)
+				if err == nil {
+					err = validateSpan(span)
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				q = q.Where("timestamp > (SELECT MAX(timestamp) FROM ?) - ?", bun.Ident(assets[asset]), int64(span/time.Second))
+				limit = min(int(span/time.Minute), maxListLimit)
+			}
+			if v := r.URL.Query().Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 || n > maxListLimit {
+					http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxListLimit), http.StatusBadRequest)
+					return
+				}
+				limit = n
+			}
+			var stats *logStats
+			if v := r.URL.Query().Get("stats"); v != "" {
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					http.Error(w, "invalid stats: "+v, http.StatusBadRequest)
+					return
+				}
+				if b {
+					stats = &logStats{field: cfg.defaults.field()}
+				}
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+			defer cancel()
+
+			rows, err := q.Limit(limit).Rows(ctx)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer rows.Close()
+
+			w.Header().Set("content-type", "application/json")
+			w.Header().Add("Vary", "Accept-Encoding")
+			var out io.Writer = w
+			if acceptsGzip(r) {
+				w.Header().Set("Content-Encoding", "gzip")
+				gz := gzip.NewWriter(w)
+				defer gz.Close()
+				out = gz
+			}
+			if err := writeListing(ctx, out, bundb, rows, stats); err != nil {
+				slog.Error("failed to list rows", "error", err)
+			}
+			return
+		}
+		if cfg.authToken != "" {
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.authToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		var ev nostr.Event
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEventSize)).Decode(&ev)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if ok, err := ev.CheckSignature(); !ok || ev.GetID() != ev.ID {
+			msg := "invalid signature"
+			if err != nil {
+				msg += ": " + err.Error()
+			}
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		eev, err := respond(r.Context(), bundb, cfg, &ev)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var re *requestError
+			if errors.As(err, &re) {
+				status = re.status
+			}
+			http.Error(w, err.Error(), status)
+			return
 		}
-		eev.Sign(sk)
 
 		w.Header().Set("content-type", "text/json; charset=utf-8")
+		if len(cfg.relays) > 0 {
+			json.NewEncoder(w).Encode(map[string]any{
+				"event":  eev,
+				"relays": publish(r.Context(), cfg.relays, eev),
+			})
+			return
+		}
 		json.NewEncoder(w).Encode(eev)
 	}
 }
 
+// chartPNG serves the chart as PNG without uploading it.
+func chartPNG(bundb *bun.DB, defaults chartOptions) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span := 180 * time.Minute
+		if v := r.URL.Query().Get("span"); v != "" {
+			d, err := parseLongDuration(v)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			span = d
+		}
+		opts := defaults
+		span, err := opts.window(span)
+		if err == nil {
+			err = validateSpan(span)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		chartRequests.Inc()
+		release, err := acquireRenderSlot(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+
+		data, points, err := fetchPoints(ctx, bundb, int(span/time.Minute), &opts)
+		if err == nil {
+			h := w.Header()
+			h.Set("content-type", "image/png")
+			h.Set("X-Data-First", time.Unix(int64(points[0].X), 0).UTC().Format(time.RFC3339))
+			h.Set("X-Data-Last", time.Unix(int64(points[len(points)-1].X), 0).UTC().Format(time.RFC3339))
+			h.Set("X-Data-Points", strconv.Itoa(len(points)))
+			h.Set("X-Price-Last", strconv.FormatFloat(points[len(points)-1].Y, 'f', -1, 64))
+			err = drawPoints(ctx, w, bundb, data, points, int(span/time.Minute), opts, "png")
+		}
+		if errors.Is(err, errNoData) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			generateFailures.Inc()
+			slog.Error("failed to render chart", "span", span, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func versionInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"name":     name,
+		"version":  version,
+		"revision": revision,
+	})
+}
+
+// acceptsGzip reports whether the client of r accepts gzip encoded
+// responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(v), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		f, err := strconv.ParseFloat(q, 64)
+		return err == nil && f > 0
+	}
+	return false
+}
+
+// writeListing writes rows as a JSON array, or along with their stats
+// unless stats is nil.
+func writeListing(ctx context.Context, w io.Writer, bundb *bun.DB, rows *sql.Rows, stats *logStats) error {
+	if stats == nil {
+		return writeLogs(ctx, w, bundb, rows, nil)
+	}
+	if _, err := io.WriteString(w, `{"points":`); err != nil {
+		return err
+	}
+	if err := writeLogs(ctx, w, bundb, rows, stats); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `,"stats":`); err != nil {
+		return err
+	}
+	if stats.n == 0 {
+		stats = nil
+	}
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
+
+// logStats summarizes the prices of a window the same way the chart title
+// does. Rows are added newest first.
+type logStats struct {
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Avg       float64 `json:"avg"`
+	First     float64 `json:"first"`
+	Last      float64 `json:"last"`
+	ChangePct float64 `json:"change_pct"`
+
+	field string
+	sum   float64
+	n     int
+}
+
+func (s *logStats) add(d BtcLog) {
+	v := d.price(s.field)
+	if s.n == 0 {
+		s.Min, s.Max, s.Last = v, v, v
+	}
+	s.Min, s.Max = math.Min(s.Min, v), math.Max(s.Max, v)
+	s.First = v
+	s.sum += v
+	s.n++
+	s.Avg = s.sum / float64(s.n)
+	if s.First != 0 {
+		s.ChangePct = percentChange(s.First, s.Last)
+	}
+}
+
+// writeLogs streams rows to w as a JSON array, adding each to stats unless
+// it is nil.
+func writeLogs(ctx context.Context, w io.Writer, bundb *bun.DB, rows *sql.Rows, stats *logStats) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i := 0; rows.Next(); i++ {
+		var d BtcLog
+		if err := bundb.ScanRow(ctx, rows, &d); err != nil {
+			return err
+		}
+		if stats != nil {
+			stats.add(d)
+		}
+		b, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			b = append([]byte{','}, b...)
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "]\n")
+	return err
+}
+
+func healthz(bundb *bun.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+
+		w.Header().Set("content-type", "application/json")
+		if err := bundb.PingContext(ctx); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"status": "error", "error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}
+}
+
+// dbPool is the connection pool configuration applied to PostgreSQL.
+type dbPool struct {
+	maxOpen  int
+	maxIdle  int
+	lifetime time.Duration
+}
+
+func openDB(dsn string, pool dbPool) (*bun.DB, error) {
+	if path, ok := strings.CutPrefix(dsn, "sqlite://"); ok {
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			return nil, err
+		}
+		return bun.NewDB(db, sqlitedialect.New()), nil
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(pool.maxOpen)
+	db.SetMaxIdleConns(pool.maxIdle)
+	db.SetConnMaxLifetime(pool.lifetime)
+	return bun.NewDB(db, pgdialect.New()), nil
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func init() {
 }
 
@@ -299,46 +1725,357 @@ func main() {
 	var ver bool
 	var span time.Duration
 	var output string
+	var opts chartOptions
+	var currencyCode string
+	var baseCode string
+	var relays string
+	var width, height string
+	var themeName string
+	var tz string
+	var logLevel string
+	var cacheTTL time.Duration
+	var bb string
+	var ingestInterval time.Duration
+	var ingestURL string
+	var listLimit int
+	var rateLimit int
+	var pool dbPool
+	var noUpload bool
+	var configPath string
+	var fontPath string
+	var renderConcurrency int
+	var httpTimeout time.Duration
+	var httpProxy, httpCAFile string
+	var since, until string
+	var kinds string
+	var replyAs int
+	var listenRelays string
+	var listenCommand string
+	var alertAbove, alertBelow float64
+	var alertInterval time.Duration
+	var retention time.Duration
+	var annotationsPath string
+	var authToken string
+	var publicURL string
 
-	flag.StringVar(&dsn, "dsn", os.Getenv("DATABASE_URL"), "Database source")
-	flag.DurationVar(&span, "span", 180*time.Minute, "span")
+	flag.StringVar(&dsn, "dsn", os.Getenv(envFlags["dsn"]), "Database source")
+	flag.IntVar(&pool.maxOpen, "db-max-open", 10, "maximum open PostgreSQL connections (0 means unlimited)")
+	flag.IntVar(&pool.maxIdle, "db-max-idle", 5, "maximum idle PostgreSQL connections")
+	flag.DurationVar(&pool.lifetime, "db-conn-lifetime", 30*time.Minute, "maximum lifetime of a PostgreSQL connection (0 means forever)")
+	span = 180 * time.Minute
+	flag.Func("span", "span, like 3h, 1d, 2w, 1M or 1y (default 3h)", func(v string) (err error) {
+		span, err = parseLongDuration(v)
+		return err
+	})
 	flag.StringVar(&output, "output", "", "output filename")
+	flag.StringVar(&opts.ChartType, "chart-type", "line", "chart type (line, candlestick, histogram)")
+	flag.DurationVar(&opts.Interval, "candle-interval", 0, "candlestick interval (0 means auto)")
+	flag.StringVar(&opts.Format, "format", "png", "image format (png, svg, jpeg, pdf, webp)")
+	flag.StringVar(&opts.Watermark, "watermark", "", "attribution, like the npub of the bot or a URL, drawn faintly in the bottom right corner")
+	flag.StringVar(&opts.Title, "title-template", defaultTitle, "chart title; {symbol}, {price}, {change}, {span} and {asset} are expanded")
+	flag.Func("ymin", "fixed lower bound of the Y axis", func(v string) error { return opts.set("ymin", v) })
+	flag.Func("ymax", "fixed upper bound of the Y axis", func(v string) error { return opts.set("ymax", v) })
+	flag.Float64Var(&opts.YPad, "ypad", 0, "pad the Y axis range by this percentage on each side")
+	flag.Float64Var(&opts.MaxGap, "max-gap", 3, "break the line where samples are this many median intervals apart (0 means never)")
+	flag.StringVar(&opts.Grid, "grid", "major", "gridlines (off, major, minor)")
+	flag.Func("assets", "additional assets as key=table (comma separated)", addAssets)
+	flag.StringVar(&opts.Field, "field", "ask", "price field to plot (last, bid, ask)")
+	flag.IntVar(&opts.SMA, "sma", 0, "simple moving average window (0 means disabled)")
+	flag.IntVar(&opts.EMA, "ema", 0, "exponential moving average window (0 means disabled)")
+	flag.StringVar(&bb, "bbands", "", "Bollinger Bands period and multiplier (e.g. 20,2)")
+	flag.BoolVar(&opts.Spread, "show-spread", false, "shade bid/ask spread")
+	flag.IntVar(&opts.MinPoints, "min-points", 0, "refuse to chart spans with fewer price points than this")
+	flag.Func("color-by-direction", "color the price line by direction: off, net (the whole line by the net change) or segments (default off)", func(v string) error { return opts.set("direction", v) })
+	flag.BoolVar(&opts.ASCII, "ascii", false, "print a text sparkline and exit instead of serving (requests ask for one with the ascii token)")
+	flag.Func("line-width", "width of the price line in points (default 1)", func(v string) error { return opts.set("linewidth", v) })
+	flag.BoolVar(&opts.Markers, "show-markers", false, "mark each sample on the price line")
+	flag.BoolVar(&opts.HighLow, "show-highlow", false, "mark the highest and lowest prices")
+	flag.BoolVar(&opts.Drawdown, "show-drawdown", false, "shade the drop from the running high")
+	flag.BoolVar(&opts.Crosshair, "show-crosshair", false, "draw lines at the latest time and price")
+	flag.Func("resample", "average the price line over buckets of this length (e.g. 1d, 1w)", func(v string) error { return opts.set("resample", v) })
+	flag.BoolVar(&opts.Smooth, "smooth", false, "draw a smooth curve through sparse samples")
+	flag.BoolVar(&opts.Range, "show-range", false, "show the time range of the data beneath the chart")
+	flag.BoolVar(&opts.Outliers, "filter-outliers", false, "drop samples far off from their neighbors")
+	flag.BoolVar(&opts.LogScale, "log-scale", false, "use logarithmic Y axis")
+	flag.BoolVar(&opts.Volume, "show-volume", false, "show sample count per bucket beneath the price")
+	flag.BoolVar(&opts.Abbreviate, "abbreviate-y", false, "abbreviate Y axis labels (e.g. 9.5M)")
+	flag.StringVar(&annotationsPath, "annotations", "", "JSON file of {timestamp, label} to mark on the chart")
+	flag.StringVar(&since, "since", "", "start of an absolute window like 2024-05-01 or 2024-05-01T09:00, instead of the latest --span")
+	flag.StringVar(&until, "until", "", "end of an absolute window (defaults to --since plus --span)")
+	flag.DurationVar(&httpTimeout, "http-timeout", httpClient.Timeout, "timeout of outbound HTTP requests (ticker, FX rates and uploads)")
+	flag.StringVar(&httpProxy, "http-proxy", "", "proxy for outbound HTTP requests (default: HTTPS_PROXY and HTTP_PROXY)")
+	flag.StringVar(&httpCAFile, "http-ca-file", "", "PEM file of extra CA certificates to trust for outbound HTTPS requests")
+	flag.IntVar(&renderConcurrency, "render-concurrency", runtime.NumCPU(), "maximum number of charts fetched and drawn at once")
+	flag.StringVar(&fontPath, "font", "", "TrueType or OpenType font for the chart text, e.g. a CJK font (default: gonum's Liberation Serif)")
+	flag.StringVar(&themeName, "theme", "dark", "color theme (dark, light)")
+	flag.StringVar(&tz, "tz", "Asia/Tokyo", "time zone for the time axis (IANA name)")
+	flag.Func("dpi", "resolution of raster images (default 96, up to 300); scales pixel sizes too", func(v string) error { return opts.set("dpi", v) })
+	flag.StringVar(&width, "width", "5in", "image width (in inches or 96 dpi pixels, e.g. 5in, 800px)")
+	flag.StringVar(&height, "height", "4in", "image height (in inches or 96 dpi pixels, e.g. 4in, 600px)")
+	flag.StringVar(&currencyCode, "currency", "JPY", "fiat currency code")
+	flag.StringVar(&baseCode, "base-currency", "", "currency the prices are stored in, converted to --currency with a live FX rate when different")
+	flag.StringVar(&fxURL, "fx-url", fxURL, "exchange rate endpoint for --base-currency ({base} and {quote} are replaced)")
+	flag.StringVar(&listenRelays, "listen-relays", "", "relays to watch for mentions and reply to (comma separated)")
+	flag.StringVar(&listenCommand, "listen-command", "chart", "first word of the mentions to reply to")
+	flag.Float64Var(&alertAbove, "alert-above", 0, "publish a note when the price rises above this level (0 means disabled)")
+	flag.Float64Var(&alertBelow, "alert-below", 0, "publish a note when the price falls below this level (0 means disabled)")
+	flag.DurationVar(&alertInterval, "alert-interval", time.Minute, "how often the price is checked for alerts")
+	flag.StringVar(&kinds, "kinds", "1", "event kinds to respond to (comma separated)")
+	flag.IntVar(&replyAs, "reply-kind", 0, "kind of the replies (0 to keep text kinds like 1 and 42 and use 1 otherwise)")
+	flag.StringVar(&relays, "relays", "", "relays to publish replies to (comma separated)")
+	flag.StringVar(&uploadURL, "upload-url", legacyUploadURL, "upload endpoint (legacy nostr.build endpoint or NIP-96 server)")
+	flag.StringVar(&publicURL, "public-url", "", "URL this server is reachable at, to serve charts from when uploading fails")
+	flag.BoolVar(&noUpload, "no-upload", false, "save charts to temporary files instead of uploading")
+	flag.StringVar(&uploadField, "upload-field", "", "multipart field name of the upload (default: fileToUpload for the legacy endpoint, file for NIP-96)")
+	flag.StringVar(&uploadFilename, "upload-filename", uploadFilename, "file name of the upload, without the extension")
+	flag.StringVar(&blossomURL, "blossom-url", "", "Blossom server to upload to instead of --upload-url")
+	flag.DurationVar(&ingestInterval, "ingest-interval", 0, "interval to fetch and store the ticker (0 means disabled)")
+	flag.StringVar(&ingestURL, "ingest-url", "https://coincheck.com/api/ticker", "ticker API to ingest from")
+	flag.Func("retention", "delete rows older than this, e.g. 90d (disabled by default)", func(v string) error {
+		d, err := parseLongDuration(v)
+		if err == nil && d <= 0 {
+			err = errors.New("retention must be positive")
+		}
+		retention = d
+		return err
+	})
+	flag.IntVar(&listLimit, "list-limit", 180, "number of rows returned by GET by default")
+	flag.StringVar(&authToken, "auth-token", os.Getenv(envFlags["auth-token"]), "token POST requests must send as Authorization: Bearer <token>")
+	flag.IntVar(&rateLimit, "rate-limit", 10, "requests per minute allowed for each pubkey (0 means unlimited)")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 60*time.Second, "how long generated charts are reused (0 means disabled)")
+	flag.StringVar(&logLevel, "log-level", "info", "log level (debug, info, warn, error)")
+	flag.StringVar(&configPath, "config", "", "YAML config file with flag values and nsec")
 	flag.BoolVar(&ver, "v", false, "show version")
 	flag.Parse()
 
+	var conf map[string]string
+	if configPath != "" {
+		var err error
+		conf, err = loadConfig(configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := applyConfig(flag.CommandLine, conf); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if ver {
-		fmt.Println(version)
+		fmt.Printf("%s %s (rev: %s)\n", name, version, revision)
 		os.Exit(0)
 	}
 
-	time.Local = time.FixedZone("Local", 9*60*60)
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
 
-	db, err := sql.Open("postgres", dsn)
+	if err := opts.set("type", opts.ChartType); err != nil {
+		log.Fatal(err)
+	}
+	if err := opts.set("format", opts.Format); err != nil {
+		log.Fatal(err)
+	}
+	if bb != "" {
+		if err := opts.set("bbands", bb); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := opts.set("grid", opts.Grid); err != nil {
+		log.Fatal(err)
+	}
+	if err := opts.set("field", opts.Field); err != nil {
+		log.Fatal(err)
+	}
+	if err := opts.set("theme", themeName); err != nil {
+		log.Fatal(err)
+	}
+	if err := opts.set("width", width); err != nil {
+		log.Fatal(err)
+	}
+	if err := opts.set("height", height); err != nil {
+		log.Fatal(err)
+	}
+	if cur, ok := currencies[strings.ToUpper(currencyCode)]; ok {
+		opts.Currency = cur
+	} else {
+		log.Fatalf("unknown currency: %s", currencyCode)
+	}
+	if baseCode != "" {
+		if cur, ok := currencies[strings.ToUpper(baseCode)]; ok {
+			opts.Base = cur
+		} else {
+			log.Fatalf("unknown base currency: %s", baseCode)
+		}
+	}
+
+	if fontPath != "" {
+		if err := loadFont(fontPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if annotationsPath != "" {
+		notes, err := loadAnnotations(annotationsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		annotations = notes
+	}
+
+	loc, err := time.LoadLocation(tz)
 	if err != nil {
 		log.Fatal(err)
 	}
+	opts.Location = loc
+	if since != "" {
+		if err := opts.set("since", since); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if until != "" {
+		if err := opts.set("until", until); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	bundb := bun.NewDB(db, pgdialect.New())
+	if httpTimeout <= 0 {
+		log.Fatal("http-timeout must be positive")
+	}
+	if httpClient, err = newHTTPClient(httpTimeout, httpProxy, httpCAFile); err != nil {
+		log.Fatal(err)
+	}
+	if renderConcurrency < 1 {
+		log.Fatalf("invalid render-concurrency: %d", renderConcurrency)
+	}
+	renderSlots = make(chan struct{}, renderConcurrency)
+	if opts.MinPoints < 0 {
+		log.Fatalf("invalid min-points: %d", opts.MinPoints)
+	}
+	bundb, err := openDB(dsn, pool)
+	if err != nil {
+		log.Fatal(err)
+	}
 	defer bundb.Close()
 
+	if opts.ASCII {
+		span, err := opts.window(span)
+		if err != nil {
+			log.Fatal(err)
+		}
+		text, err := renderSparkline(context.Background(), bundb, int(span/time.Minute), opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(text)
+		return
+	}
+
 	if output != "" {
-		_, err := generate(bundb, int(span/time.Minute), output, nil)
+		span, err := opts.window(span)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = generate(context.Background(), bundb, int(span/time.Minute), opts, output, nil)
 		if err != nil {
 			log.Fatal(err)
 		}
 		return
 	}
 
+	if listLimit < 1 || listLimit > maxListLimit {
+		log.Fatalf("list-limit must be between 1 and %d", maxListLimit)
+	}
+
+	if ingestInterval > 0 {
+		go ingest(context.Background(), bundb, ingestURL, ingestInterval)
+	}
+	if retention > 0 {
+		go prune(context.Background(), bundb, retention, time.Hour)
+	}
+
 	nsec := os.Getenv("NULLPOGA_NSEC")
+	if nsec == "" {
+		nsec = conf["nsec"]
+	}
+	if nsec == "" && noUpload {
+		nsec, _ = nip19.EncodePrivateKey(nostr.GeneratePrivateKey())
+		slog.Warn("NULLPOGA_NSEC is not set, using a throwaway key")
+	}
 	if nsec == "" {
 		log.Fatal("NULLPOGA_NSEC is not set")
 	}
+	sk, pub, err := decodeKey(nsec)
+	if err != nil {
+		log.Fatalf("NULLPOGA_NSEC is invalid: %v", err)
+	}
+
+	var up Uploader = &httpUploader{
+		sign: func(ev *nostr.Event) error {
+			ev.PubKey = pub
+			return ev.Sign(sk)
+		},
+	}
+	if noUpload {
+		up = tempUploader{}
+	}
+	if uploadFilename == "" || strings.ContainsAny(uploadFilename, `"/\`) {
+		log.Fatalf("invalid upload filename: %q", uploadFilename)
+	}
+	if publicURL != "" {
+		fb := newFallbackUploader(up, publicURL)
+		http.Handle("/charts/", fb)
+		up = fb
+	}
+
+	if replyAs < 0 {
+		log.Fatalf("invalid reply kind: %d", replyAs)
+	}
+	kindSet := map[int]bool{}
+	for _, k := range strings.Split(kinds, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(k))
+		if err != nil {
+			log.Fatalf("invalid kind: %s", k)
+		}
+		kindSet[n] = true
+	}
+
+	cfg := &handlerConfig{
+		sk:        sk,
+		pub:       pub,
+		defaults:  opts,
+		relays:    splitList(relays),
+		cache:     newChartCache(cacheTTL),
+		limiter:   newRateLimiter(rateLimit),
+		upload:    up,
+		kinds:     kindSet,
+		replyKind: replyAs,
+		authToken: authToken,
+		listLimit: listLimit,
+	}
+	if alertAbove > 0 || alertBelow > 0 {
+		if len(cfg.relays) == 0 {
+			log.Fatal("alerts require --relays")
+		}
+		if alertInterval <= 0 {
+			log.Fatal("alert-interval must be positive")
+		}
+		go watchAlerts(context.Background(), bundb, cfg, alertAbove, alertBelow, alertInterval)
+	}
+	if l := splitList(listenRelays); len(l) > 0 {
+		go listen(context.Background(), bundb, cfg, l, listenCommand)
+	}
 
-	http.HandleFunc("/", handler(bundb, nsec))
+	http.HandleFunc("/", handler(bundb, cfg))
+	http.HandleFunc("/chart.png", chartPNG(bundb, opts))
+	http.HandleFunc("/healthz", healthz(bundb))
+	http.HandleFunc("/version", versionInfo)
+	http.Handle("/metrics", promhttp.Handler())
 	addr := ":" + os.Getenv("PORT")
 	if addr == ":" {
 		addr = ":8080"
 	}
-	log.Printf("started %v", addr)
+	slog.Info("started", "addr", addr)
 	http.ListenAndServe(addr, nil)
 }
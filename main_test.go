@@ -0,0 +1,673 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/uptrace/bun"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// testEnd is the timestamp of the latest row seeded by newTestDB.
+var testEnd = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Unix()
+
+// newTestDB returns an in-memory database holding n rows, one a minute and
+// ending at testEnd.
+func newTestDB(t *testing.T, n int) *bun.DB {
+	t.Helper()
+	return newTestDBEvery(t, n, time.Minute)
+}
+
+// newTestDBEvery is like newTestDB but with a row every interval.
+func newTestDBEvery(t *testing.T, n int, interval time.Duration) *bun.DB {
+	t.Helper()
+	bundb, err := openDB("sqlite://:memory:", dbPool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a database of its own
+	bundb.SetMaxOpenConns(1)
+	t.Cleanup(func() { bundb.Close() })
+
+	ctx := context.Background()
+	if _, err := bundb.NewCreateTable().Model((*BtcLog)(nil)).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		return bundb
+	}
+	rows := make([]BtcLog, n)
+	for i := range rows {
+		price := 9500000 + 100000*math.Sin(float64(i)/30)
+		rows[i] = BtcLog{
+			Timestamp: testEnd - int64(n-1-i)*int64(interval/time.Second),
+			Last:      price,
+			Bid:       price - 2000,
+			Ask:       price + 2000,
+			CreatedAt: time.Unix(testEnd, 0),
+		}
+	}
+	// in chunks to stay under the number of variables sqlite allows
+	for len(rows) > 0 {
+		chunk := rows[:min(len(rows), 5000)]
+		if _, err := bundb.NewInsert().Model(&chunk).Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+		rows = rows[len(chunk):]
+	}
+	return bundb
+}
+
+// testOptions returns the chart options main uses by default.
+func testOptions(t *testing.T) chartOptions {
+	t.Helper()
+	opts := chartOptions{
+		ChartType: "line",
+		Format:    "png",
+		Currency:  currencies["JPY"],
+		Location:  time.UTC,
+	}
+	for k, v := range map[string]string{"theme": "dark", "width": "5in", "height": "4in"} {
+		if err := opts.set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return opts
+}
+
+// stubUploader records the uploads instead of sending them anywhere.
+type stubUploader struct {
+	mu      sync.Mutex
+	formats []string
+}
+
+func (u *stubUploader) Upload(ctx context.Context, buf *bytes.Buffer, format string) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.formats = append(u.formats, format)
+	return "https://example.com/chart." + format, nil
+}
+
+func newTestConfig(t *testing.T) *handlerConfig {
+	t.Helper()
+	sk := nostr.GeneratePrivateKey()
+	pub, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &handlerConfig{
+		sk:        sk,
+		pub:       pub,
+		defaults:  testOptions(t),
+		cache:     newChartCache(0),
+		limiter:   newRateLimiter(0),
+		upload:    &stubUploader{},
+		kinds:     map[int]bool{nostr.KindTextNote: true},
+		listLimit: 100,
+	}
+}
+
+// signedEvent returns a text note with content signed by a new key.
+func signedEvent(t *testing.T, content string, tags ...nostr.Tag) nostr.Event {
+	t.Helper()
+	ev := nostr.Event{
+		Kind:      nostr.KindTextNote,
+		Content:   content,
+		CreatedAt: nostr.Now(),
+		Tags:      tags,
+	}
+	if err := ev.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+// post sends ev to a handler serving bundb with cfg.
+func post(t *testing.T, bundb *bun.DB, cfg *handlerConfig, ev nostr.Event) *httptest.ResponseRecorder {
+	t.Helper()
+	b, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler(bundb, cfg)(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b)))
+	return w
+}
+
+func TestHandlerReply(t *testing.T) {
+	bundb := newTestDB(t, 300)
+	cfg := newTestConfig(t)
+	ev := signedEvent(t, "chart 3h")
+
+	w := post(t, bundb, cfg, ev)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var reply nostr.Event
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := reply.CheckSignature(); !ok {
+		t.Fatalf("reply signature is invalid: %v", err)
+	}
+	if reply.PubKey != cfg.pub {
+		t.Errorf("pubkey = %s, want %s", reply.PubKey, cfg.pub)
+	}
+	if !strings.HasPrefix(reply.Content, "https://example.com/chart.png\n") {
+		t.Errorf("content = %q", reply.Content)
+	}
+
+	if tag := reply.Tags.GetFirst([]string{"e", ev.ID}); tag == nil || len(*tag) < 4 || (*tag)[3] != "root" {
+		t.Errorf("e tag = %v, want the request as root", tag)
+	}
+	if tag := reply.Tags.GetFirst([]string{"p", ev.PubKey}); tag == nil {
+		t.Errorf("no p tag for the author in %v", reply.Tags)
+	}
+	if tag := reply.Tags.GetFirst([]string{"t", "ビットコインチャート"}); tag == nil {
+		t.Errorf("no t tag in %v", reply.Tags)
+	}
+	imeta := reply.Tags.GetFirst([]string{"imeta"})
+	if imeta == nil {
+		t.Fatalf("no imeta tag in %v", reply.Tags)
+	}
+	for _, want := range []string{"url https://example.com/chart.png", "m image/png", "dim 480x384"} {
+		found := false
+		for _, v := range (*imeta)[1:] {
+			found = found || v == want
+		}
+		if !found {
+			t.Errorf("imeta %v lacks %q", *imeta, want)
+		}
+	}
+
+	up := cfg.upload.(*stubUploader)
+	if len(up.formats) != 1 || up.formats[0] != "png" {
+		t.Errorf("uploads = %v, want one png", up.formats)
+	}
+}
+
+func TestHandlerNoData(t *testing.T) {
+	bundb := newTestDB(t, 0)
+	cfg := newTestConfig(t)
+
+	w := post(t, bundb, cfg, signedEvent(t, "chart 3h"))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+	if up := cfg.upload.(*stubUploader); len(up.formats) != 0 {
+		t.Errorf("uploaded %v without data", up.formats)
+	}
+}
+
+func TestXTicks(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name   string
+		min    time.Time
+		span   time.Duration
+		ticks  int
+		labels int
+		first  string
+	}{
+		{"3 hours", time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local), 3 * time.Hour, 19, 19, "12:00"},
+		{"12 hours", time.Date(2024, 5, 1, 6, 0, 0, 0, time.Local), 12 * time.Hour, 13, 13, "06:00"},
+		// rounded to the hour and labelled with the time of day, so it
+		// must be stepped hourly too
+		{"24 hours", time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local), 88000 * time.Second, 25, 25, "00:00"},
+		{"5 days", time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local), 5 * day, 6, 6, "05/01"},
+		{"30 days", time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local), 30 * day, 31, 7, "05/01"},
+		{"120 days", time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local), 120 * day, 27, 8, "01/01"},
+		{"1 year", time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local), 364 * day, 24, 12, "2023/01"},
+		{"3 years", time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local), 3 * 365 * day, 37, 4, "2021/01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			min := float64(tt.min.Unix())
+			max := float64(tt.min.Add(tt.span).Unix())
+			ticks := XTicks{}.Ticks(min, max)
+			if len(ticks) != tt.ticks {
+				t.Errorf("got %d ticks, want %d", len(ticks), tt.ticks)
+			}
+			var labels []string
+			for _, tick := range ticks {
+				if tick.Value < min || tick.Value > max {
+					t.Errorf("tick at %v is outside [%v, %v]", tick.Value, min, max)
+				}
+				if tick.Label != "" {
+					labels = append(labels, tick.Label)
+				}
+			}
+			if len(labels) != tt.labels {
+				t.Errorf("got %d labels %v, want %d", len(labels), labels, tt.labels)
+			}
+			if len(labels) > 0 && labels[0] != tt.first {
+				t.Errorf("first label = %q, want %q", labels[0], tt.first)
+			}
+		})
+	}
+}
+
+func TestChartTitle(t *testing.T) {
+	usd := testOptions(t)
+	usd.Currency = currencies["USD"]
+	custom := testOptions(t)
+	custom.Title = "{asset} {span} {change}"
+	tests := []struct {
+		name   string
+		points plotter.XYs
+		opts   chartOptions
+		want   string
+		pct    float64
+	}{
+		{"rise", plotter.XYs{{Y: 9000000}, {Y: 9500000}, {Y: 9900000}}, testOptions(t), "₿ ¥ 9,900,000 (+10.0%)", 10},
+		{"fall", plotter.XYs{{Y: 10000000}, {Y: 9876543}}, testOptions(t), "₿ ¥ 9,876,543 (-1.2%)", -1.23457},
+		{"flat", plotter.XYs{{Y: 9500000}, {Y: 9500000}}, testOptions(t), "₿ ¥ 9,500,000 (0.0%)", 0},
+		{"tiny fall", plotter.XYs{{Y: 10000000}, {Y: 9999000}}, testOptions(t), "₿ ¥ 9,999,000 (0.0%)", -0.01},
+		{"single point", plotter.XYs{{Y: 9500000}}, testOptions(t), "₿ ¥ 9,500,000", 0},
+		{"decimals", plotter.XYs{{Y: 60000}, {Y: 61234.5}}, usd, "₿ $ 61,234.50 (+2.1%)", 2.0575},
+		{"template", plotter.XYs{{Y: 100}, {Y: 95}}, custom, "BTC 3h (-5.0%)", -5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, pct := chartTitle(tt.points, 180, tt.opts)
+			if got != tt.want {
+				t.Errorf("title = %q, want %q", got, tt.want)
+			}
+			if math.Abs(pct-tt.pct) > 0.001 {
+				t.Errorf("change = %v, want %v", pct, tt.pct)
+			}
+		})
+	}
+}
+
+func TestHandlerInvalidOption(t *testing.T) {
+	bundb := newTestDB(t, 300)
+	cfg := newTestConfig(t)
+	for _, content := range []string{
+		"chart 24h bogus",
+		"chart 24h sma=abc",
+		"chart 1s",
+		"chart ymin=5 ymax=1",
+		"chart ymin=99999999",
+		"chart log=true ymin=-5",
+	} {
+		if w := post(t, bundb, cfg, signedEvent(t, content)); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want %d", content, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestHandlerTamperedEvent(t *testing.T) {
+	bundb := newTestDB(t, 300)
+	cfg := newTestConfig(t)
+
+	// the content no longer matches the id
+	ev := signedEvent(t, "chart 3h")
+	ev.Content = "chart 24h"
+	if w := post(t, bundb, cfg, ev); w.Code != http.StatusBadRequest {
+		t.Errorf("changed content: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// the id matches but the signature doesn't
+	ev = signedEvent(t, "chart 3h")
+	ev.Tags = nostr.Tags{{"e", strings.Repeat("0", 64), "", "root"}}
+	ev.ID = ev.GetID()
+	if w := post(t, bundb, cfg, ev); w.Code != http.StatusBadRequest {
+		t.Errorf("changed tags: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if up := cfg.upload.(*stubUploader); len(up.formats) != 0 {
+		t.Errorf("uploaded %v for a tampered event", up.formats)
+	}
+}
+
+func TestNoData(t *testing.T) {
+	ctx := context.Background()
+	up := &stubUploader{}
+	_, err := generate(ctx, newTestDB(t, 0), 180, testOptions(t), "", up)
+	if !errors.Is(err, errNoData) {
+		t.Errorf("empty table: err = %v, want %v", err, errNoData)
+	}
+	if len(up.formats) != 0 {
+		t.Errorf("uploaded %v without data", up.formats)
+	}
+
+	// rows exist, but none in the requested window
+	bundb := newTestDB(t, 300)
+	cfg := newTestConfig(t)
+	w := post(t, bundb, cfg, signedEvent(t, "chart 2020-01-01 2020-01-02"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("empty window: status = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+}
+
+func TestXTicksYears(t *testing.T) {
+	min := float64(time.Date(2021, 6, 15, 0, 0, 0, 0, time.UTC).Unix())
+	max := float64(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).Unix())
+	var labels []string
+	for _, tick := range (XTicks{Location: time.UTC}).Ticks(min, max) {
+		if tick.Label != "" {
+			labels = append(labels, tick.Label)
+		}
+	}
+	want := []string{"2022/01", "2023/01", "2024/01"}
+	if strings.Join(labels, " ") != strings.Join(want, " ") {
+		t.Errorf("labels = %v, want %v", labels, want)
+	}
+}
+
+func TestRenderChart(t *testing.T) {
+	bundb := newTestDB(t, 300)
+	for _, chartType := range []string{"line", "candlestick", "histogram"} {
+		t.Run(chartType, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.ChartType = chartType
+			buf, err := renderChart(context.Background(), bundb, 180, opts, "png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := img.Bounds(); b.Dx() != 480 || b.Dy() != 384 {
+				t.Errorf("image is %dx%d, want 480x384", b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestParseContent(t *testing.T) {
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC).Unix()
+	until := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC).Unix()
+	tests := []struct {
+		content string
+		span    time.Duration
+		format  string
+		sma     int
+		since   int64
+		until   int64
+	}{
+		{content: "chart", span: 180 * time.Minute, format: "png"},
+		{content: "chart 24h", span: 24 * time.Hour, format: "png"},
+		{content: "  chart   24h ", span: 24 * time.Hour, format: "png"},
+		{content: "chart\t24h\tsvg", span: 24 * time.Hour, format: "svg"},
+		{content: "chart\n\n6h", span: 6 * time.Hour, format: "png"},
+		{content: "24h", span: 24 * time.Hour, format: "png"},
+		{content: "chart svg 1w", span: 7 * 24 * time.Hour, format: "svg"},
+		{content: "chart sma=20 jpeg  12h", span: 12 * time.Hour, format: "jpeg", sma: 20},
+		{content: "chart 2024-05-01 2024-05-02", span: 180 * time.Minute, format: "png", since: since, until: until},
+	}
+	for _, tt := range tests {
+		span, opts, err := parseContent(tt.content, testOptions(t))
+		if err != nil {
+			t.Errorf("%q: %v", tt.content, err)
+			continue
+		}
+		if span != tt.span {
+			t.Errorf("%q: span = %v, want %v", tt.content, span, tt.span)
+		}
+		if opts.Format != tt.format {
+			t.Errorf("%q: format = %q, want %q", tt.content, opts.Format, tt.format)
+		}
+		if opts.SMA != tt.sma {
+			t.Errorf("%q: sma = %d, want %d", tt.content, opts.SMA, tt.sma)
+		}
+		if opts.Since != tt.since || opts.Until != tt.until {
+			t.Errorf("%q: window = %d..%d, want %d..%d", tt.content, opts.Since, opts.Until, tt.since, tt.until)
+		}
+	}
+
+	for _, content := range []string{"chart 24h bogus", "chart 24h sma=abc", "chart nosuch=1"} {
+		if _, _, err := parseContent(content, testOptions(t)); err == nil {
+			t.Errorf("%q: no error", content)
+		}
+	}
+}
+
+func TestReplyKind(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     int
+		override int
+		want     int
+	}{
+		{"text note", nostr.KindTextNote, 0, nostr.KindTextNote},
+		{"channel message", nostr.KindChannelMessage, 0, nostr.KindChannelMessage},
+		{"metadata", nostr.KindProfileMetadata, 0, nostr.KindTextNote},
+		{"replaceable", 10002, 0, nostr.KindTextNote},
+		{"parameterized replaceable", 30023, 0, nostr.KindTextNote},
+		{"override text note", nostr.KindTextNote, nostr.KindChannelMessage, nostr.KindChannelMessage},
+		{"override replaceable", 30023, 1111, 1111},
+	}
+	for _, tt := range tests {
+		if got := replyKind(tt.kind, tt.override); got != tt.want {
+			t.Errorf("%s: replyKind(%d, %d) = %d, want %d", tt.name, tt.kind, tt.override, got, tt.want)
+		}
+	}
+}
+
+// TestConcurrentRequests is meant to be run with -race.
+func TestConcurrentRequests(t *testing.T) {
+	bundb := newTestDB(t, 600)
+	cfg := newTestConfig(t)
+	ts := httptest.NewServer(http.HandlerFunc(handler(bundb, cfg)))
+	defer ts.Close()
+
+	contents := []string{"chart 3h", "chart 6h type=candlestick", "chart 9h svg", "chart 2h type=histogram", "chart 5h sma=10"}
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < cap(errs); i++ {
+		b, err := json.Marshal(signedEvent(t, contents[i%len(contents)]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(b))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				errs <- fmt.Errorf("status = %s", resp.Status)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if up := cfg.upload.(*stubUploader); len(up.formats) != cap(errs) {
+		t.Errorf("got %d uploads, want %d", len(up.formats), cap(errs))
+	}
+}
+
+func TestParseLongDuration(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		s    string
+		want time.Duration
+	}{
+		{"1d", day},
+		{"3d", 3 * day},
+		{"1w", 7 * day},
+		{"2w", 14 * day},
+		{"1M", 30 * day},
+		{"6M", 180 * day},
+		{"1y", 365 * day},
+		{"24h", day},
+		{"90m", 90 * time.Minute},
+		{"30s", 30 * time.Second},
+		{"1h30m", 90 * time.Minute},
+	}
+	for _, tt := range tests {
+		got, err := parseLongDuration(tt.s)
+		if err != nil {
+			t.Errorf("%q: %v", tt.s, err)
+		} else if got != tt.want {
+			t.Errorf("%q = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+	for _, s := range []string{"", "d", "1x", "1.5.d", "abc", "1dd"} {
+		if _, err := parseLongDuration(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestFetchPointsInterval(t *testing.T) {
+	// a week of samples every 5 minutes
+	bundb := newTestDBEvery(t, 7*24*12, 5*time.Minute)
+	opts := testOptions(t)
+	_, points, err := fetchPoints(context.Background(), bundb, 24*60, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 24*12 {
+		t.Errorf("got %d points, want %d", len(points), 24*12)
+	}
+	if got := points[len(points)-1].X - points[0].X; got >= 24*60*60 {
+		t.Errorf("points cover %v, want less than a day", time.Duration(got)*time.Second)
+	}
+}
+
+func TestFetchPointsLongSpan(t *testing.T) {
+	// 60 days of minute samples, more than maxListLimit
+	const span = 60 * 24 * 60
+	bundb := newTestDB(t, span)
+	opts := testOptions(t)
+	_, points, err := fetchPoints(context.Background(), bundb, span, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) > maxListLimit {
+		t.Errorf("got %d points, want at most %d", len(points), maxListLimit)
+	}
+	if got := points[len(points)-1].X - points[0].X; got < (span-10)*60 {
+		t.Errorf("points cover %v, want the whole span", time.Duration(got)*time.Second)
+	}
+	if last := points[len(points)-1].X; last < float64(testEnd-180) {
+		t.Errorf("last point at %v, want close to %v", last, testEnd)
+	}
+
+	opts.Compare = 24 * time.Hour
+	compare, err := comparePoints(context.Background(), bundb, points, span, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compare) == 0 || len(compare) > maxListLimit {
+		t.Errorf("got %d compare points, want 1 to %d", len(compare), maxListLimit)
+	}
+}
+
+func TestListLongSpan(t *testing.T) {
+	bundb := newTestDB(t, 300)
+	cfg := newTestConfig(t)
+	w := httptest.NewRecorder()
+	handler(bundb, cfg)(w, httptest.NewRequest(http.MethodGet, "/?span=1y", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var rows []BtcLog
+	if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 300 {
+		t.Errorf("got %d rows, want 300", len(rows))
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	tests := []struct {
+		s    string
+		want vg.Length
+	}{
+		{"5", 5 * vg.Inch},
+		{"5in", 5 * vg.Inch},
+		{"480px", 5 * vg.Inch},
+		{"800px", 800 * vg.Inch / 96},
+	}
+	for _, tt := range tests {
+		got, err := parseLength(tt.s)
+		if err != nil {
+			t.Errorf("%q: %v", tt.s, err)
+		} else if math.Abs(float64(got-tt.want)) > 1e-9 {
+			t.Errorf("%q = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+	for _, s := range []string{"", "1in", "21in", "100px", "5cm"} {
+		if _, err := parseLength(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+
+	// pixels are at 96 dpi, so twice the dpi is twice the pixels
+	opts := testOptions(t)
+	for k, v := range map[string]string{"width": "800px", "dpi": "192"} {
+		if err := opts.set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := opts.Width.Dots(float64(opts.dpi())); math.Abs(w-1600) > 1e-6 {
+		t.Errorf("800px at 192 dpi is %vpx, want 1600px", w)
+	}
+}
+
+func TestRenderSlotsBoundFetch(t *testing.T) {
+	saved := renderSlots
+	renderSlots = make(chan struct{}, 1)
+	renderSlots <- struct{}{}
+	t.Cleanup(func() { renderSlots = saved })
+
+	// with every slot taken nothing must be fetched from the closed
+	// database before the deadline
+	bundb := newTestDB(t, 300)
+	bundb.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := renderChart(ctx, bundb, 180, testOptions(t), "png"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := renderSparkline(ctx, bundb, 180, testOptions(t)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("sparkline: err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestChartPNGWindow(t *testing.T) {
+	bundb := newTestDB(t, 600)
+	opts := testOptions(t)
+	since := time.Unix(testEnd-500*60, 0).UTC()
+	if err := opts.set("since", since.Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+
+	// only since is given, so the window is the hour after it
+	w := httptest.NewRecorder()
+	chartPNG(bundb, opts)(w, httptest.NewRequest(http.MethodGet, "/chart.png?span=1h", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if got, want := w.Header().Get("X-Data-First"), since.Format(time.RFC3339); got != want {
+		t.Errorf("X-Data-First = %s, want %s", got, want)
+	}
+	if got, want := w.Header().Get("X-Data-Last"), since.Add(59*time.Minute).Format(time.RFC3339); got != want {
+		t.Errorf("X-Data-Last = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	chartRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btcchart_requests_total",
+		Help: "Total number of chart requests.",
+	})
+	generateFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btcchart_generate_failures_total",
+		Help: "Total number of failed chart generations.",
+	})
+	uploadFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "btcchart_upload_failures_total",
+		Help: "Total number of failed uploads.",
+	})
+	uploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "btcchart_upload_duration_seconds",
+		Help:    "Time spent uploading charts.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+	renderDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "btcchart_render_duration_seconds",
+		Help:    "Time spent rendering charts.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 10),
+	})
+)
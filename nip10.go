@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// replyTags returns the NIP-10 marked e tags and the p tags for a reply
+// to ev.
+func replyTags(ev *nostr.Event) nostr.Tags {
+	var root nostr.Tag
+	var unmarked []nostr.Tag
+	for _, tag := range ev.Tags {
+		if tag.Key() != "e" || len(tag) < 2 {
+			continue
+		}
+		if len(tag) >= 4 && tag[3] == "root" {
+			root = tag
+			break
+		}
+		if len(tag) < 4 || tag[3] == "" {
+			unmarked = append(unmarked, tag)
+		}
+	}
+	// deprecated positional e tags: the first one is the root
+	if root == nil && len(unmarked) > 0 {
+		root = unmarked[0]
+	}
+
+	var tags nostr.Tags
+	if root == nil || root.Value() == ev.ID {
+		tags = appendUnique(tags, nostr.Tag{"e", ev.ID, "", "root"})
+	} else {
+		relay := ""
+		if len(root) > 2 {
+			relay = root[2]
+		}
+		tags = appendUnique(tags, nostr.Tag{"e", root.Value(), relay, "root"})
+		tags = appendUnique(tags, nostr.Tag{"e", ev.ID, "", "reply"})
+	}
+
+	tags = appendUnique(tags, nostr.Tag{"p", ev.PubKey})
+	for _, tag := range ev.Tags {
+		if tag.Key() == "p" && len(tag) >= 2 {
+			tags = appendUnique(tags, nostr.Tag{"p", tag.Value()})
+		}
+	}
+	return tags
+}
+
+// appendUnique appends tag unless tags already has a tag with the same key
+// and value, whatever their relay hints and markers are, so that the same
+// event or pubkey is referenced only once. Unlike Tags.AppendUnique the
+// value must match exactly rather than by prefix.
+func appendUnique(tags nostr.Tags, tag nostr.Tag) nostr.Tags {
+	for _, t := range tags {
+		if len(t) >= 2 && t[0] == tag[0] && t[1] == tag[1] {
+			return tags
+		}
+	}
+	return append(tags, tag)
+}
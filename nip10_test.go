@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestReplyTags(t *testing.T) {
+	id := strings.Repeat("1", 64)
+	root := strings.Repeat("2", 64)
+	parent := strings.Repeat("3", 64)
+	author := strings.Repeat("a", 64)
+	other := strings.Repeat("b", 64)
+
+	tests := []struct {
+		name string
+		tags nostr.Tags
+		want nostr.Tags
+	}{
+		{
+			name: "thread root",
+			want: nostr.Tags{{"e", id, "", "root"}, {"p", author}},
+		},
+		{
+			name: "marked root",
+			tags: nostr.Tags{
+				{"e", parent, "", "reply"},
+				{"e", root, "wss://relay.example.com", "root"},
+				{"p", other},
+			},
+			want: nostr.Tags{
+				{"e", root, "wss://relay.example.com", "root"},
+				{"e", id, "", "reply"},
+				{"p", author},
+				{"p", other},
+			},
+		},
+		{
+			name: "positional",
+			tags: nostr.Tags{{"e", root}, {"e", parent}, {"p", author}},
+			want: nostr.Tags{{"e", root, "", "root"}, {"e", id, "", "reply"}, {"p", author}},
+		},
+		{
+			name: "root is itself",
+			tags: nostr.Tags{{"e", id, "", "root"}},
+			want: nostr.Tags{{"e", id, "", "root"}, {"p", author}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := &nostr.Event{ID: id, PubKey: author, Tags: tt.tags}
+			if got := replyTags(ev); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replyTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplyTagsDuplicates(t *testing.T) {
+	id := strings.Repeat("1", 64)
+	root := strings.Repeat("2", 64)
+	author := strings.Repeat("a", 64)
+
+	ev := &nostr.Event{ID: id, PubKey: author, Tags: nostr.Tags{
+		{"e", root},
+		{"e", root, "wss://relay.example.com", "root"},
+		{"e", id},
+		{"p", author},
+		{"p", author, "wss://relay.example.com"},
+	}}
+	want := nostr.Tags{
+		{"e", root, "wss://relay.example.com", "root"},
+		{"e", id, "", "reply"},
+		{"p", author},
+	}
+	if got := replyTags(ev); !reflect.DeepEqual(got, want) {
+		t.Errorf("replyTags() = %v, want %v", got, want)
+	}
+}
+
+func TestAppendUnique(t *testing.T) {
+	id := strings.Repeat("1", 64)
+	tags := nostr.Tags{{"e", id, "", "root"}}
+
+	// the same id without a marker is a duplicate
+	if got := appendUnique(tags, nostr.Tag{"e", id}); len(got) != 1 {
+		t.Errorf("unmarked copy was appended: %v", got)
+	}
+	// a prefix of the id is not
+	if got := appendUnique(tags, nostr.Tag{"e", id[:8]}); len(got) != 2 {
+		t.Errorf("prefix was not appended: %v", got)
+	}
+	// nor is the same value under another key
+	if got := appendUnique(tags, nostr.Tag{"p", id}); len(got) != 2 {
+		t.Errorf("p tag was not appended: %v", got)
+	}
+}
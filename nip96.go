@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var (
+	nip96Mu     sync.Mutex
+	nip96APIURL = map[string]string{}
+)
+
+func isLegacyUploadURL(s string) bool {
+	return s == legacyUploadURL || strings.HasSuffix(s, "/ios.php")
+}
+
+// discoverNIP96 looks up the api_url of a NIP-96 server from its
+// /.well-known/nostr/nip96.json.
+func discoverNIP96(ctx context.Context, server string) (string, error) {
+	nip96Mu.Lock()
+	apiURL, ok := nip96APIURL[server]
+	nip96Mu.Unlock()
+	if ok {
+		return apiURL, nil
+	}
+
+	origin := server
+	for i := 0; i < 2; i++ {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return "", err
+		}
+		u.Path = "/.well-known/nostr/nip96.json"
+		u.RawQuery = ""
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			return "", fmt.Errorf("%s: %s", u, resp.Status)
+		}
+		var info struct {
+			APIURL         string `json:"api_url"`
+			DelegatedToURL string `json:"delegated_to_url"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&info)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", u, err)
+		}
+		if info.APIURL != "" {
+			apiURL = info.APIURL
+			break
+		}
+		if info.DelegatedToURL == "" {
+			return "", fmt.Errorf("%s: api_url is not provided", u)
+		}
+		origin = info.DelegatedToURL
+	}
+	if apiURL == "" {
+		return "", errors.New("too many delegations")
+	}
+
+	nip96Mu.Lock()
+	nip96APIURL[server] = apiURL
+	nip96Mu.Unlock()
+	return apiURL, nil
+}
+
+func parseNIP96Response(r io.Reader) (string, error) {
+	var result struct {
+		Status     string      `json:"status"`
+		Message    string      `json:"message"`
+		NIP94Event nostr.Event `json:"nip94_event"`
+	}
+	err := json.NewDecoder(r).Decode(&result)
+	if err != nil {
+		return "", err
+	}
+	if result.Status != "success" {
+		return "", errors.New(result.Message)
+	}
+	if tag := result.NIP94Event.Tags.GetFirst([]string{"url", ""}); tag != nil {
+		return tag.Value(), nil
+	}
+	return "", errors.New("url is not found in nip94_event")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// outlierWindow is the number of neighbors on each side a sample is
+	// compared against.
+	outlierWindow = 5
+	// outlierK is how many scaled median absolute deviations a sample may
+	// be away from the median of its neighbors.
+	outlierK = 5
+	// outlierMinDeviation is the smallest deviation, relative to the
+	// median, that counts as an outlier so that flat data keeps its
+	// small moves.
+	outlierMinDeviation = 0.01
+)
+
+// filterOutliers removes the rows whose field deviates from the median of
+// their neighbors by more than outlierK scaled median absolute deviations,
+// like a zero or a 10x spike from an exchange glitch. It returns the kept
+// rows and the number of rows dropped.
+func filterOutliers(data []BtcLog, field string) ([]BtcLog, int) {
+	if len(data) < 3 {
+		return data, 0
+	}
+	result := make([]BtcLog, 0, len(data))
+	window := make([]float64, 0, 2*outlierWindow+1)
+	for i, d := range data {
+		window = window[:0]
+		for _, n := range data[max(i-outlierWindow, 0):min(i+outlierWindow+1, len(data))] {
+			window = append(window, n.price(field))
+		}
+		med := median(window)
+		for j, v := range window {
+			window[j] = math.Abs(v - med)
+		}
+		threshold := math.Max(outlierK*1.4826*median(window), outlierMinDeviation*math.Abs(med))
+		if math.Abs(d.price(field)-med) > threshold {
+			continue
+		}
+		result = append(result, d)
+	}
+	return result, len(data) - len(result)
+}
+
+// median returns the median of values. values is sorted in place.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// pruneOnce deletes the rows of every asset older than retention.
+func pruneOnce(ctx context.Context, bundb *bun.DB, retention time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	cutoff := time.Now().Add(-retention).Unix()
+	for asset, table := range assets {
+		res, err := bundb.NewDelete().Model((*BtcLog)(nil)).
+			ModelTableExpr("? AS f", bun.Ident(table)).
+			Where("timestamp < ?", cutoff).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		slog.Info("pruned rows", "asset", asset, "rows", n, "before", time.Unix(cutoff, 0))
+	}
+	return nil
+}
+
+func prune(ctx context.Context, bundb *bun.DB, retention, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		if err := pruneOnce(ctx, bundb, retention); err != nil {
+			slog.Error("failed to prune rows", "error", err)
+		}
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterIdle is how long a pubkey's limiter is kept after its last
+// request.
+const rateLimiterIdle = 10 * time.Minute
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter allows each pubkey perMinute requests per minute.
+type rateLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	entries   map[string]*limiterEntry
+	lastSweep time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		perMinute: perMinute,
+		entries:   map[string]*limiterEntry{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *rateLimiter) allow(pubkey string) bool {
+	if l.perMinute <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > rateLimiterIdle {
+		for k, e := range l.entries {
+			if now.Sub(e.lastSeen) > rateLimiterIdle {
+				delete(l.entries, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	e, ok := l.entries[pubkey]
+	if !ok {
+		e = &limiterEntry{
+			limiter: rate.NewLimiter(rate.Every(time.Minute/time.Duration(l.perMinute)), l.perMinute),
+		}
+		l.entries[pubkey] = e
+	}
+	e.lastSeen = now
+	return e.limiter.AllowN(now, 1)
+}
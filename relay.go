@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// publish sends ev to each relay and reports "ok" or the failure reason
+// for each of them.
+func publish(ctx context.Context, relays []string, ev nostr.Event) map[string]string {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	result := map[string]string{}
+	for _, url := range relays {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			status := "ok"
+			if err := publishOne(ctx, url, ev); err != nil {
+				slog.Warn("failed to publish", "relay", url, "id", ev.ID, "error", err)
+				status = err.Error()
+			}
+			mu.Lock()
+			result[url] = status
+			mu.Unlock()
+		}(url)
+	}
+	wg.Wait()
+	return result
+}
+
+func publishOne(ctx context.Context, url string, ev nostr.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+	return relay.Publish(ctx, ev)
+}
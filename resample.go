@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// resample aggregates points into buckets of interval, each represented by
+// the mean of its points at the start of the bucket. Buckets are aligned to
+// midnight in loc so that daily buckets are calendar days. Unlike
+// downsample it averages rather than selects, giving a smoother series.
+func resample(points plotter.XYs, interval time.Duration, loc *time.Location) plotter.XYs {
+	if interval <= 0 || len(points) == 0 {
+		return points
+	}
+	_, offset := time.Unix(int64(points[0].X), 0).In(loc).Zone()
+	size := interval.Seconds()
+
+	var result plotter.XYs
+	var sum float64
+	var n int
+	for i, pt := range points {
+		t := math.Floor((pt.X+float64(offset))/size)*size - float64(offset)
+		if i > 0 && t != result[len(result)-1].X {
+			result[len(result)-1].Y = sum / float64(n)
+			sum, n = 0, 0
+		}
+		if n == 0 {
+			result = append(result, plotter.XY{X: t})
+		}
+		sum += pt.Y
+		n++
+	}
+	result[len(result)-1].Y = sum / float64(n)
+	return result
+}
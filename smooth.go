@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// smooth interpolates points with a monotone cubic Hermite spline
+// (Fritsch–Carlson), adding steps-1 points between each pair. Unlike a
+// natural spline it doesn't overshoot, so the curve never goes beyond the
+// prices of the surrounding samples.
+func smooth(points plotter.XYs, steps int) plotter.XYs {
+	n := len(points)
+	if steps < 2 || n < 3 {
+		return points
+	}
+
+	// secants and initial tangents
+	d := make([]float64, n-1)
+	for i := range d {
+		d[i] = (points[i+1].Y - points[i].Y) / (points[i+1].X - points[i].X)
+	}
+	m := make([]float64, n)
+	m[0], m[n-1] = d[0], d[n-2]
+	for i := 1; i < n-1; i++ {
+		if d[i-1]*d[i] > 0 {
+			m[i] = (d[i-1] + d[i]) / 2
+		}
+	}
+	// limit the tangents to keep each segment monotone
+	for i, s := range d {
+		if s == 0 {
+			m[i], m[i+1] = 0, 0
+			continue
+		}
+		a, b := m[i]/s, m[i+1]/s
+		if h := math.Hypot(a, b); h > 3 {
+			t := 3 / h
+			m[i], m[i+1] = t*a*s, t*b*s
+		}
+	}
+
+	result := make(plotter.XYs, 0, (n-1)*steps+1)
+	for i := 0; i < n-1; i++ {
+		p0, p1 := points[i], points[i+1]
+		h := p1.X - p0.X
+		for j := 0; j < steps; j++ {
+			t := float64(j) / float64(steps)
+			t2, t3 := t*t, t*t*t
+			y := (2*t3-3*t2+1)*p0.Y + (t3-2*t2+t)*h*m[i] + (-2*t3+3*t2)*p1.Y + (t3-t2)*h*m[i+1]
+			result = append(result, plotter.XY{X: p0.X + t*h, Y: y})
+		}
+	}
+	return append(result, points[n-1])
+}
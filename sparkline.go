@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"gonum.org/v1/plot/plotter"
+)
+
+// sparkWidth is the number of characters of a sparkline.
+const sparkWidth = 40
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws points as at most width block characters, each the
+// average of its share of the points.
+func sparkline(points plotter.XYs, width int) string {
+	n := min(width, len(points))
+	values := make([]float64, n)
+	for i := range values {
+		from, to := i*len(points)/n, (i+1)*len(points)/n
+		var sum float64
+		for _, pt := range points[from:to] {
+			sum += pt.Y
+		}
+		values[i] = sum / float64(to-from)
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	var sb strings.Builder
+	for _, v := range values {
+		i := len(sparkBlocks) / 2
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1))
+		}
+		sb.WriteRune(sparkBlocks[i])
+	}
+	return sb.String()
+}
+
+// renderSparkline returns the title of the chart of the latest span
+// minutes followed by a sparkline of it, for clients that can't show
+// images.
+func renderSparkline(ctx context.Context, bundb *bun.DB, span int, opts chartOptions) (string, error) {
+	release, err := acquireRenderSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	_, points, err := fetchPoints(ctx, bundb, span, &opts)
+	if err != nil {
+		return "", err
+	}
+	title, _ := chartTitle(points, span, opts)
+	return title + "\n" + sparkline(points, sparkWidth), nil
+}
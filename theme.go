@@ -0,0 +1,99 @@
+package main
+
+import (
+	"image/color"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+type theme struct {
+	Background color.Color
+	Foreground color.Color
+	Grid       color.Color
+	Up         color.Color
+	Down       color.Color
+}
+
+var themes = map[string]theme{
+	"dark": {
+		Background: color.Black,
+		Foreground: color.White,
+		Grid:       color.Gray{Y: 128},
+		Up:         color.RGBA{R: 50, G: 255, B: 100, A: 255},
+		Down:       color.RGBA{R: 255, G: 60, B: 60, A: 255},
+	},
+	"light": {
+		Background: color.White,
+		Foreground: color.Black,
+		Grid:       color.Gray{Y: 200},
+		Up:         color.RGBA{R: 0, G: 150, B: 60, A: 255},
+		Down:       color.RGBA{R: 210, G: 30, B: 30, A: 255},
+	},
+}
+
+// grid returns the gridlines for mode, which is off, major or minor.
+// minor draws fainter dashed lines at the minor ticks as well. grid
+// returns nil for off.
+func (t theme) grid(mode string) *gridLines {
+	if mode == "off" {
+		return nil
+	}
+	g := &gridLines{Major: plotter.DefaultGridLineStyle}
+	g.Major.Color = t.Grid
+	if mode == "minor" {
+		g.Minor = draw.LineStyle{
+			Color:  t.Grid,
+			Width:  vg.Points(0.25),
+			Dashes: []vg.Length{vg.Points(1), vg.Points(2)},
+		}
+	}
+	return g
+}
+
+// gridLines is like plotter.Grid but can also draw lines at minor ticks.
+type gridLines struct {
+	Major draw.LineStyle
+	Minor draw.LineStyle
+}
+
+func (g *gridLines) style(tk plot.Tick) (draw.LineStyle, bool) {
+	if tk.IsMinor() {
+		return g.Minor, g.Minor.Color != nil
+	}
+	return g.Major, true
+}
+
+func (g *gridLines) Plot(c draw.Canvas, plt *plot.Plot) {
+	trX, trY := plt.Transforms(&c)
+	for _, tk := range plt.X.Tick.Marker.Ticks(plt.X.Min, plt.X.Max) {
+		ls, ok := g.style(tk)
+		x := trX(tk.Value)
+		if !ok || x < c.Min.X || x > c.Max.X {
+			continue
+		}
+		c.StrokeLine2(ls, x, c.Min.Y, x, c.Max.Y)
+	}
+	for _, tk := range plt.Y.Tick.Marker.Ticks(plt.Y.Min, plt.Y.Max) {
+		ls, ok := g.style(tk)
+		y := trY(tk.Value)
+		if !ok || y < c.Min.Y || y > c.Max.Y {
+			continue
+		}
+		c.StrokeLine2(ls, c.Min.X, y, c.Max.X, y)
+	}
+}
+
+func (t theme) apply(p *plot.Plot) {
+	p.BackgroundColor = t.Background
+	p.Title.TextStyle.Color = t.Foreground
+	for _, a := range []*plot.Axis{&p.X, &p.Y} {
+		a.Color = t.Foreground
+		a.Label.TextStyle.Color = t.Foreground
+		a.LineStyle.Color = t.Foreground
+		a.Tick.Color = t.Foreground
+		a.Tick.Label.Color = t.Foreground
+	}
+}
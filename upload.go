@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"time"
+
+	"github.com/mattn/go-nostrbuild"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const legacyUploadURL = "https://nostr.build/api/v2/upload/files"
+
+var uploadURL = legacyUploadURL
+
+// uploadField is the multipart field the chart is sent in. Empty picks the
+// field the kind of uploadURL expects.
+var uploadField string
+
+// uploadFilename is the name the chart is sent as, without the extension
+// which follows the format.
+var uploadFilename = "chart"
+
+var mimeTypes = map[string]string{
+	"png":  "image/png",
+	"svg":  "image/svg+xml",
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"pdf":  "application/pdf",
+	"webp": "image/webp",
+}
+
+const uploadAttempts = 3
+
+// chartImage is an uploaded chart along with the NIP-94 metadata clients
+// use to lay it out before fetching it.
+type chartImage struct {
+	URL      string
+	MimeType string
+	Width    int
+	Height   int
+	Size     int
+	SHA256   string
+}
+
+func newChartImage(url string, b []byte, format string, width, height int) chartImage {
+	sum := sha256.Sum256(b)
+	return chartImage{
+		URL:      url,
+		MimeType: mimeTypes[format],
+		Width:    width,
+		Height:   height,
+		Size:     len(b),
+		SHA256:   hex.EncodeToString(sum[:]),
+	}
+}
+
+// imeta returns the NIP-92 imeta tag describing img.
+func (img chartImage) imeta() nostr.Tag {
+	return nostr.Tag{
+		"imeta",
+		"url " + img.URL,
+		"m " + img.MimeType,
+		fmt.Sprintf("dim %dx%d", img.Width, img.Height),
+		"x " + img.SHA256,
+		fmt.Sprintf("size %d", img.Size),
+	}
+}
+
+type uploadError struct {
+	err       error
+	retryable bool
+}
+
+func (e *uploadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.err
+}
+
+// Uploader stores a rendered chart somewhere and returns where it can be
+// fetched from.
+type Uploader interface {
+	Upload(ctx context.Context, buf *bytes.Buffer, format string) (string, error)
+}
+
+// httpUploader uploads to the Blossom server when blossomURL is set, or
+// else to uploadURL, authenticating with events signed by sign.
+type httpUploader struct {
+	sign func(*nostr.Event) error
+}
+
+func (u *httpUploader) Upload(ctx context.Context, buf *bytes.Buffer, format string) (string, error) {
+	start := time.Now()
+	url, err := u.uploadFile(ctx, buf, format)
+	uploadDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		uploadFailures.Inc()
+		slog.Error("upload failed", "format", format, "size", buf.Len(), "duration", time.Since(start), "error", err)
+		return "", err
+	}
+	slog.Info("uploaded chart", "url", url, "format", format, "size", buf.Len(), "duration", time.Since(start))
+	return url, nil
+}
+
+func (u *httpUploader) uploadFile(ctx context.Context, buf *bytes.Buffer, format string) (string, error) {
+	// the upload may take longer than the query deadline of the caller
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+
+	if blossomURL != "" {
+		return retry(ctx, func() (string, error) {
+			return uploadBlossom(ctx, blossomURL, buf.Bytes(), format, u.sign)
+		})
+	}
+
+	endpoint, field, parse := uploadURL, "fileToUpload", parseLegacyResponse
+	if !isLegacyUploadURL(uploadURL) {
+		apiURL, err := discoverNIP96(ctx, uploadURL)
+		if err != nil {
+			return "", err
+		}
+		endpoint, field, parse = apiURL, "file", parseNIP96Response
+	}
+	if uploadField != "" {
+		field = uploadField
+	}
+
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s.%s"`, field, uploadFilename, format))
+	h.Set("Content-Type", mimeTypes[format])
+	part, err := w.CreatePart(h)
+	if err != nil {
+		return "", err
+	}
+	_, err = part.Write(buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return retry(ctx, func() (string, error) {
+		return uploadOnce(ctx, endpoint, b.Bytes(), w.FormDataContentType(), u.sign, parse)
+	})
+}
+
+// tempUploader saves the chart to a temporary file and returns its path.
+type tempUploader struct{}
+
+func (tempUploader) Upload(ctx context.Context, buf *bytes.Buffer, format string) (string, error) {
+	f, err := os.CreateTemp("", "btcchart-*."+format)
+	if err != nil {
+		return "", err
+	}
+	if _, err = buf.WriteTo(f); err != nil {
+		f.Close()
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// retry calls f until it succeeds, fails with a non-retryable error, or
+// runs out of attempts. The wait between attempts doubles each time.
+func retry(ctx context.Context, f func() (string, error)) (string, error) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		url, err := f()
+		if err == nil {
+			return url, nil
+		}
+		var ue *uploadError
+		if !errors.As(err, &ue) || !ue.retryable || attempt == uploadAttempts {
+			return "", err
+		}
+		slog.Warn("upload attempt failed", "attempt", attempt, "max", uploadAttempts, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return "", err
+		}
+		backoff *= 2
+	}
+}
+
+func uploadOnce(ctx context.Context, endpoint string, body []byte, contentType string, sign func(*nostr.Event) error, parse func(io.Reader) (string, error)) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	if sign != nil {
+		var ev nostr.Event
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"u", endpoint})
+		ev.Tags = ev.Tags.AppendUnique(nostr.Tag{"method", "POST"})
+		ev.Kind = 27235
+		ev.CreatedAt = nostr.Now()
+		err = sign(&ev)
+		if err != nil {
+			return "", err
+		}
+		b, err := ev.MarshalJSON()
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Nostr "+base64.StdEncoding.EncodeToString(b))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// network errors and timeouts are worth another try
+		return "", &uploadError{err: err, retryable: true}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(resp.Body)
+		return "", &uploadError{
+			err:       fmt.Errorf("upload failed: %s: %s", resp.Status, string(b)),
+			retryable: resp.StatusCode >= 500,
+		}
+	}
+
+	return parse(resp.Body)
+}
+
+func parseLegacyResponse(r io.Reader) (string, error) {
+	var result nostrbuild.Response
+	err := json.NewDecoder(r).Decode(&result)
+	if err != nil {
+		return "", err
+	}
+	if len(result.Data) == 0 {
+		return "", errors.New(result.Message)
+	}
+	return result.Data[0].URL, nil
+}
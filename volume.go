@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"time"
+
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// newVolumePlot counts the samples in each bucket of interval seconds. It
+// is a proxy for activity since BtcLog has no real volume column.
+func newVolumePlot(points plotter.XYs, interval float64, th theme, grid string, loc *time.Location) (*plot.Plot, error) {
+	var bins []plotter.HistogramBin
+	for _, pt := range points {
+		t := math.Floor(pt.X/interval) * interval
+		if len(bins) == 0 || bins[len(bins)-1].Min != t {
+			bins = append(bins, plotter.HistogramBin{Min: t, Max: t + interval})
+		}
+		bins[len(bins)-1].Weight++
+	}
+
+	h := &plotter.Histogram{
+		Bins:      bins,
+		Width:     interval,
+		FillColor: color.RGBA{R: 50, G: 160, B: 255, A: 255},
+		LineStyle: draw.LineStyle{Color: th.Background, Width: vg.Points(0.5)},
+	}
+
+	p := plot.New()
+	th.apply(p)
+	if g := th.grid(grid); g != nil {
+		p.Add(g)
+	}
+	p.Add(h)
+
+	p.X.LineStyle.Width = vg.Points(1)
+	p.X.Tick.Marker = XTicks{Location: loc}
+	p.X.Tick.Label.Rotation = math.Pi / 3
+	p.X.Tick.Label.XAlign = -1.2
+
+	p.Y.Min = 0
+	p.Y.LineStyle.Width = vg.Points(1)
+	p.Y.Tick.Marker = hplot.Ticks{N: 3, Format: "%.0f"}
+	return p, nil
+}
+
+type noLabels struct {
+	plot.Ticker
+}
+
+func (t noLabels) Ticks(min, max float64) []plot.Tick {
+	ticks := t.Ticker.Ticks(min, max)
+	for i := range ticks {
+		ticks[i].Label = ""
+	}
+	return ticks
+}
+
+// drawStacked draws top over bottom, giving bottom the given fraction of
+// the height, and lines up their data areas horizontally.
+func drawStacked(c draw.Canvas, top, bottom *plot.Plot, fraction float64) {
+	split := c.Min.Y + vg.Length(fraction)*(c.Max.Y-c.Min.Y)
+	tc := draw.Crop(c, 0, 0, split-c.Min.Y, 0)
+	bc := draw.Crop(c, 0, 0, 0, split-c.Max.Y)
+
+	tdc, bdc := top.DataCanvas(tc), bottom.DataCanvas(bc)
+	left := math.Max(float64(tdc.Min.X-tc.Min.X), float64(bdc.Min.X-bc.Min.X))
+	right := math.Max(float64(tc.Max.X-tdc.Max.X), float64(bc.Max.X-bdc.Max.X))
+	tc = draw.Crop(tc, vg.Length(left)-(tdc.Min.X-tc.Min.X), (tc.Max.X-tdc.Max.X)-vg.Length(right), 0, 0)
+	bc = draw.Crop(bc, vg.Length(left)-(bdc.Min.X-bc.Min.X), (bc.Max.X-bdc.Max.X)-vg.Length(right), 0, 0)
+
+	c.SetColor(top.BackgroundColor)
+	c.Fill(c.Rectangle.Path())
+	top.Draw(tc)
+	bottom.Draw(bc)
+}
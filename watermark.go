@@ -0,0 +1,32 @@
+package main
+
+import (
+	"image/color"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/font"
+	"gonum.org/v1/plot/text"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// drawWatermark draws txt faintly in the bottom right corner of c, in a
+// strip of its own so it never covers the data or the tick labels, and
+// returns the rest of c for the chart.
+func drawWatermark(c draw.Canvas, txt string, th theme) draw.Canvas {
+	r, g, b, _ := th.Foreground.RGBA()
+	sty := text.Style{
+		Color:   color.NRGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 128},
+		Font:    font.From(plot.DefaultFont, 7),
+		XAlign:  text.XRight,
+		YAlign:  text.YBottom,
+		Handler: plot.DefaultTextHandler,
+	}
+	pad := vg.Points(3)
+	strip := sty.Height(txt) + 2*pad
+
+	c.SetColor(th.Background)
+	c.Fill(c.Rectangle.Path())
+	c.FillText(sty, vg.Point{X: c.Max.X - pad, Y: c.Min.Y + pad}, txt)
+	return draw.Crop(c, 0, 0, strip, 0)
+}
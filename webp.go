@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+
+	"github.com/HugoSmits86/nativewebp"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+)
+
+// webpCanvas is a raster canvas encoded as WebP since gonum doesn't
+// support it as a format.
+type webpCanvas struct {
+	*vgimg.Canvas
+}
+
+func (c webpCanvas) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	err := nativewebp.Encode(cw, c.Image(), nil)
+	return cw.n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// newCanvas returns a canvas encoding to format. Raster formats are
+// rendered at dpi.
+func newCanvas(w, h vg.Length, format string, dpi int) (vg.CanvasWriterTo, error) {
+	raster := func() *vgimg.Canvas {
+		return vgimg.NewWith(vgimg.UseWH(w, h), vgimg.UseDPI(dpi))
+	}
+	switch format {
+	case "webp":
+		return webpCanvas{raster()}, nil
+	case "png":
+		return vgimg.PngCanvas{Canvas: raster()}, nil
+	case "jpg", "jpeg":
+		return vgimg.JpegCanvas{Canvas: raster()}, nil
+	}
+	return draw.NewFormattedCanvas(w, h, format)
+}